@@ -0,0 +1,48 @@
+package jsonrpc
+
+import "context"
+
+// RequireScopes requires callers of the named method to be authorized by the
+// Handler's Authorize function, which is given the scopes. A method may require
+// authorization without any particular scope.
+//
+// If Authorize is not specified, then calls to the method are always rejected.
+func (h *Handler) RequireScopes(name string, scopes ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.scopes == nil {
+		h.scopes = make(map[string][]string)
+	}
+	h.scopes[name] = append([]string{}, scopes...)
+}
+
+// authorize returns an error if the caller may not call the named method.
+func (h *Handler) authorize(ctx context.Context, name string) *Error {
+	h.mu.RLock()
+	scopes, ok := h.scopes[name]
+	if !ok {
+		// An alias requires the scopes of its target.
+		name = h.resolve(name)
+		scopes, ok = h.scopes[name]
+	}
+	h.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	if h.Authorize == nil {
+		return &Error{Code: StatusUnauthorized, Message: "Unauthorized"}
+	}
+	err := h.Authorize(ctx, name, scopes)
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{
+		Code:     StatusUnauthorized,
+		Message:  err.Error(),
+		original: err,
+	}
+}
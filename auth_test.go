@@ -0,0 +1,120 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireScopes(t *testing.T) {
+	called := make(chan string, 4)
+	h := NewHandler()
+	h.Authorize = func(ctx context.Context, method string, scopes []string) error {
+		r, _ := HTTPRequest(ctx)
+		if r.Header.Get("Authorization") != "Bearer admin" {
+			return errors.New("Invalid token")
+		}
+		return nil
+	}
+	h.RegisterMethod("public", func() string {
+		called <- "public"
+		return "public"
+	})
+	h.RegisterMethod("admin", func() string {
+		called <- "admin"
+		return "admin"
+	})
+	h.RequireScopes("admin", "admin:write")
+
+	// Prepare test cases.
+	type compare struct {
+		In    string
+		Token string
+		Out   string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "public"
+		}`, "", `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": "public"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "admin"
+		}`, "Bearer guest", `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"error": {
+				"code": -32001,
+				"message": "Invalid token"
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"method": "admin"
+		}`, "", ``},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"method": "admin"
+		}`, "Bearer admin", `{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"result": "admin"
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		if c.Token != "" {
+			req.Header.Set("Authorization", c.Token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+
+	if len(called) != 2 {
+		t.Fatalf("expected 2 calls, got %d", len(called))
+	}
+}
+
+func TestRequireScopesAlias(t *testing.T) {
+	h := NewHandler()
+	h.Authorize = func(ctx context.Context, method string, scopes []string) error {
+		return errors.New("Forbidden " + method)
+	}
+	h.RegisterMethod("secret", func() string { return "secret" })
+	h.RequireScopes("secret", "admin")
+	if err := h.Alias("public", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Alias("public2", "public"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Calls through an alias, or an alias of an alias, are authorized as
+	// calls to the target.
+	for i, name := range []string{"secret", "public", "public2"} {
+		t.Logf("Running test %d", i)
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "`+name+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		expectJSON(t, w.Body, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"error": {
+				"code": -32001,
+				"message": "Forbidden secret"
+			}
+		}`)
+	}
+}
@@ -0,0 +1,210 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Client makes JSON-RPC 2.0 calls over HTTP. It is safe for concurrent use.
+type Client struct {
+	// IDGenerator, if specified, returns the ID of each request as JSON, which
+	// must be a string or number, such as a quoted UUID. Since a Client may be
+	// shared, it is called concurrently and must be safe for that, and its IDs
+	// must be unique among calls in progress. By default IDs count up from 1.
+	//
+	// IDGenerator must not be changed once the Client is in use.
+	IDGenerator func() json.RawMessage
+
+	endpoint string
+	client   *http.Client
+	id       uint64
+}
+
+// NewClient initializes a new Client that sends requests to the endpoint. If
+// client is nil, then http.DefaultClient is used.
+func NewClient(endpoint string, client *http.Client) *Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Client{endpoint: endpoint, client: client}
+}
+
+type clientRequest struct {
+	Protocol string          `json:"jsonrpc"`
+	ID       json.RawMessage `json:"id,omitempty"`
+	Method   string          `json:"method"`
+	Params   json.RawMessage `json:"params,omitempty"`
+}
+
+type clientResponse struct {
+	Protocol string          `json:"jsonrpc"`
+	ID       json.RawMessage `json:"id"`
+	Result   json.RawMessage `json:"result"`
+	Error    *Error          `json:"error"`
+}
+
+// Call calls the method with the given params and unmarshals the result into
+// result, which should be a pointer. If result is nil, then the result is
+// discarded.
+//
+// Params may be a struct or map, which are sent by name, or a slice, which is
+// sent by position. Any other value is sent as the only positional param.
+//
+// If the server responds with a JSON-RPC error, then it is returned as an
+// *Error.
+//
+// The call is abandoned once ctx is done, and the error then wraps ctx.Err(),
+// such as context.DeadlineExceeded. A deadline on ctx is also sent to the
+// server in the TimeoutHeader, so that the method gives up as well.
+func (c *Client) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	id := c.nextID()
+	body, err := c.do(ctx, method, id, params)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	var res clientResponse
+	if err := json.NewDecoder(body).Decode(&res); err != nil {
+		if ctx.Err() != nil {
+			return contextError(ctx, method, err)
+		}
+		return fmt.Errorf("jsonrpc: %s: invalid response: %w", method, err)
+	}
+	if !bytes.Equal(res.ID, id) {
+		return fmt.Errorf("jsonrpc: %s: response id %s does not match request id %s", method, res.ID, id)
+	}
+	return res.unmarshal(method, result)
+}
+
+// unmarshal returns the error of the response, or else unmarshals its result
+// into result unless result is nil.
+func (res *clientResponse) unmarshal(method string, result interface{}) error {
+	if res.Error != nil {
+		return res.Error
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(res.Result, result); err != nil {
+		return fmt.Errorf("jsonrpc: %s: %w", method, err)
+	}
+	return nil
+}
+
+// nextID returns the ID of the next request.
+func (c *Client) nextID() json.RawMessage {
+	if c.IDGenerator != nil {
+		return c.IDGenerator()
+	}
+	return strconv.AppendUint(nil, atomic.AddUint64(&c.id, 1), 10)
+}
+
+// Notify sends a notification, which is a call where the server does not
+// respond. Errors from the method itself are never reported.
+func (c *Client) Notify(ctx context.Context, method string, params interface{}) error {
+	body, err := c.do(ctx, method, nil, params)
+	if err != nil {
+		return err
+	}
+	// Drain the body so the connection may be reused.
+	io.Copy(ioutil.Discard, body)
+	return body.Close()
+}
+
+func (c *Client) do(ctx context.Context, method string, id json.RawMessage, params interface{}) (io.ReadCloser, error) {
+	req, err := newClientRequest(method, id, params)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("jsonrpc: %s: %w", method, err)
+	}
+	return c.post(ctx, method, b)
+}
+
+func newClientRequest(method string, id json.RawMessage, params interface{}) (clientRequest, error) {
+	rawParams, err := marshalParams(params)
+	if err != nil {
+		return clientRequest{}, fmt.Errorf("jsonrpc: %s: %w", method, err)
+	}
+	return clientRequest{
+		Protocol: "2.0",
+		ID:       id,
+		Method:   method,
+		Params:   rawParams,
+	}, nil
+}
+
+// post sends the body to the endpoint and returns the body of the response.
+// The method describes the request in errors.
+func (c *Client) post(ctx context.Context, method string, b []byte) (io.ReadCloser, error) {
+	r, err := http.NewRequest("POST", c.endpoint, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+	if deadline, ok := ctx.Deadline(); ok {
+		// Let the server give up once the caller has.
+		if d := time.Until(deadline); d > 0 {
+			r.Header.Set(TimeoutHeader, d.String())
+		}
+	}
+
+	resp, err := c.client.Do(r)
+	if err != nil {
+		return nil, contextError(ctx, method, err)
+	}
+	if resp.StatusCode == http.StatusBadRequest && isJSONMediaType(resp.Header.Get("Content-Type")) {
+		// A server using HTTPErrorStatus still sends the JSON-RPC error.
+		defer resp.Body.Close()
+		var res clientResponse
+		if err := json.NewDecoder(resp.Body).Decode(&res); err == nil && res.Error != nil {
+			return nil, res.Error
+		}
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("jsonrpc: %s: unexpected HTTP status: %s", method, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// contextError returns the error of ctx, wrapped so that errors.Is reports
+// it, if ctx is done. Otherwise it returns err.
+func contextError(ctx context.Context, method string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("jsonrpc: %s: %w", method, ctxErr)
+	}
+	return err
+}
+
+// marshalParams marshals params as a JSON array or object. Values that do not
+// marshal as an array or object are wrapped in an array.
+func marshalParams(params interface{}) (json.RawMessage, error) {
+	if params == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	switch b[0] {
+	case '[', '{':
+		return b, nil
+	case 'n':
+		// A typed nil is the same as no params.
+		return nil, nil
+	}
+	return append(append([]byte{'['}, b...), ']'), nil
+}
@@ -0,0 +1,140 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Batch accumulates calls to send as a single JSON-RPC batch, in one round
+// trip. A Batch is not safe for concurrent use.
+type Batch struct {
+	c     *Client
+	calls []*batchCall
+}
+
+type batchCall struct {
+	method string
+	id     json.RawMessage
+	params interface{}
+	result interface{}
+}
+
+// NewBatch returns an empty Batch of calls to make using the Client.
+func (c *Client) NewBatch() *Batch {
+	return &Batch{c: c}
+}
+
+// Add adds a call of the method with the given params to the batch. Once the
+// batch is done, the result is unmarshaled into result as by Client.Call.
+func (b *Batch) Add(method string, params interface{}, result interface{}) {
+	b.calls = append(b.calls, &batchCall{
+		method: method,
+		id:     b.c.nextID(),
+		params: params,
+		result: result,
+	})
+}
+
+// Notify adds a notification of the method with the given params to the
+// batch. The server does not respond to notifications.
+func (b *Batch) Notify(method string, params interface{}) {
+	b.calls = append(b.calls, &batchCall{method: method, params: params})
+}
+
+// BatchError is returned by Batch.Do when some of the calls in a batch fail.
+type BatchError struct {
+	// Errors holds the error of each call, in the order the calls were added
+	// to the batch. It is nil for calls that succeeded and for notifications.
+	// Errors sent by the server are of type *Error.
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	var n int
+	var first error
+	for _, err := range e.Errors {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			n++
+		}
+	}
+	return fmt.Sprintf("jsonrpc: %d of %d calls failed: %v", n, len(e.Errors), first)
+}
+
+// Do sends the batch and waits for its responses, which are matched to their
+// calls by ID. If any calls fail, Do returns a *BatchError. Any other error
+// means that the batch as a whole failed. An empty batch is not sent.
+func (b *Batch) Do(ctx context.Context) error {
+	if len(b.calls) == 0 {
+		return nil
+	}
+
+	reqs := make([]clientRequest, len(b.calls))
+	pending := make(map[string]int)
+	for i, call := range b.calls {
+		req, err := newClientRequest(call.method, call.id, call.params)
+		if err != nil {
+			return err
+		}
+		reqs[i] = req
+		if call.id != nil {
+			pending[string(call.id)] = i
+		}
+	}
+	data, err := json.Marshal(reqs)
+	if err != nil {
+		return fmt.Errorf("jsonrpc: batch: %w", err)
+	}
+	body, err := b.c.post(ctx, "batch", data)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if len(pending) == 0 {
+		// Drain the body so the connection may be reused.
+		io.Copy(ioutil.Discard, body)
+		return nil
+	}
+
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return contextError(ctx, "batch", fmt.Errorf("jsonrpc: batch: %w", err))
+	}
+	var responses []clientResponse
+	if err := json.Unmarshal(raw, &responses); err != nil {
+		// The server rejects a batch as a whole with a single response.
+		var res clientResponse
+		if json.Unmarshal(raw, &res) == nil && res.Error != nil {
+			return res.Error
+		}
+		return fmt.Errorf("jsonrpc: batch: invalid response: %w", err)
+	}
+
+	errs := make([]error, len(b.calls))
+	var failed bool
+	for _, res := range responses {
+		i, ok := pending[string(res.ID)]
+		if !ok {
+			return fmt.Errorf("jsonrpc: batch: response id %s does not match any request", res.ID)
+		}
+		delete(pending, string(res.ID))
+		call := b.calls[i]
+		if err := res.unmarshal(call.method, call.result); err != nil {
+			errs[i] = err
+			failed = true
+		}
+	}
+	for _, i := range pending {
+		errs[i] = fmt.Errorf("jsonrpc: %s: no response", b.calls[i].method)
+		failed = true
+	}
+	if failed {
+		return &BatchError{Errors: errs}
+	}
+	return nil
+}
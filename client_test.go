@@ -0,0 +1,266 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	type pair struct {
+		A string `json:"a"`
+		B string `json:"b"`
+	}
+
+	notified := make(chan string, 1)
+	h := NewHandler(&Echoer{})
+	h.RegisterMethod("concat", func(p pair) string {
+		return p.A + p.B
+	})
+	h.RegisterMethod("notify", func(s string) {
+		notified <- s
+	})
+	h.RegisterMethod("fail", func() error {
+		return &Error{Code: 101, Message: "failed"}
+	})
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := NewClient(srv.URL, srv.Client())
+
+	var s string
+	if err := c.Call(ctx, "Echoer.Echo", "Hello world!", &s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "Hello world!" {
+		t.Fatalf("expected %q, got %q", "Hello world!", s)
+	}
+
+	if err := c.Call(ctx, "Echoer.DelayEcho", []interface{}{"Hello", 10}, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "Hello" {
+		t.Fatalf("expected %q, got %q", "Hello", s)
+	}
+
+	if err := c.Call(ctx, "concat", pair{"Hello ", "world!"}, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "Hello world!" {
+		t.Fatalf("expected %q, got %q", "Hello world!", s)
+	}
+
+	err := c.Call(ctx, "fail", nil, nil)
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	if e.Code != 101 || e.Message != "failed" {
+		t.Fatalf("unexpected error: %+v", e)
+	}
+
+	if err := c.Notify(ctx, "notify", "Notification"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-notified; got != "Notification" {
+		t.Fatalf("expected %q, got %q", "Notification", got)
+	}
+}
+
+func TestClientHTTPErrorStatus(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.HTTPErrorStatus = true
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	// An invalid ID is answered with 400 Bad Request, and the JSON-RPC error
+	// is still returned.
+	c := NewClient(srv.URL, srv.Client())
+	c.IDGenerator = func() json.RawMessage { return json.RawMessage(`{}`) }
+	err := c.Call(context.Background(), "Echoer.Echo", []string{"Hello world!"}, nil)
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	if e.Code != StatusInvalidRequest || e.Message != `"id" is not a valid type: {}` {
+		t.Fatalf("unexpected error: %d %s", e.Code, e.Message)
+	}
+
+	// A 400 response without a JSON-RPC error is still reported by status.
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	})
+	err = c.Call(context.Background(), "Echoer.Echo", []string{"Hello world!"}, nil)
+	if err == nil || errors.As(err, &e) {
+		t.Fatalf("expected an HTTP status error, got %v", err)
+	}
+}
+
+func TestClientIDGenerator(t *testing.T) {
+	ids := make(chan string, 1)
+	h := NewHandler()
+	h.RegisterMethod("id", func(ctx context.Context) {
+		id, _ := RequestID(ctx)
+		ids <- string(id)
+	})
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := NewClient(srv.URL, srv.Client())
+	for _, expected := range []string{"1", "2"} {
+		if err := c.Call(ctx, "id", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-ids; got != expected {
+			t.Fatalf("expected id %s, got %s", expected, got)
+		}
+	}
+
+	var n int32
+	c.IDGenerator = func() json.RawMessage {
+		return json.RawMessage(fmt.Sprintf(`"req-%d"`, atomic.AddInt32(&n, 1)))
+	}
+	for _, expected := range []string{`"req-1"`, `"req-2"`} {
+		if err := c.Call(ctx, "id", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-ids; got != expected {
+			t.Fatalf("expected id %s, got %s", expected, got)
+		}
+	}
+
+	// A response must carry the ID that was sent.
+	c.IDGenerator = func() json.RawMessage { return json.RawMessage(`"a"`) }
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc": "2.0", "id": "b", "result": null}`))
+	})
+	if err := c.Call(ctx, "id", nil, nil); err == nil {
+		t.Fatal("expected error for a mismatched response id")
+	}
+}
+
+func TestClientBatch(t *testing.T) {
+	notified := make(chan string, 1)
+	h := NewHandler(&Echoer{})
+	h.RegisterMethod("notify", func(s string) {
+		notified <- s
+	})
+	h.RegisterMethod("fail", func() error {
+		return &Error{Code: 101, Message: "failed"}
+	})
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := NewClient(srv.URL, srv.Client())
+
+	var a, b string
+	batch := c.NewBatch()
+	batch.Add("Echoer.DelayEcho", []interface{}{"first", 20}, &a)
+	batch.Notify("notify", "Hello world!")
+	batch.Add("Echoer.Echo", "second", &b)
+	if err := batch.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if a != "first" || b != "second" {
+		t.Fatalf("unexpected results %q and %q", a, b)
+	}
+	if s := <-notified; s != "Hello world!" {
+		t.Fatalf("expected notification %q, got %q", "Hello world!", s)
+	}
+
+	batch = c.NewBatch()
+	batch.Add("Echoer.Echo", "ok", &a)
+	batch.Add("fail", nil, nil)
+	batch.Add("unknown", nil, nil)
+	err := batch.Do(ctx)
+	var be *BatchError
+	if !errors.As(err, &be) {
+		t.Fatalf("expected *BatchError, got %v", err)
+	}
+	if len(be.Errors) != 3 || be.Errors[0] != nil {
+		t.Fatalf("unexpected errors: %v", be.Errors)
+	}
+	var e *Error
+	if !errors.As(be.Errors[1], &e) || e.Code != 101 {
+		t.Fatalf("expected error with code 101, got %v", be.Errors[1])
+	}
+	if !errors.As(be.Errors[2], &e) || e.Code != StatusMethodNotFound {
+		t.Fatalf("expected method not found, got %v", be.Errors[2])
+	}
+	if a != "ok" {
+		t.Fatalf("expected %q, got %q", "ok", a)
+	}
+
+	// Notifications alone get no response.
+	batch = c.NewBatch()
+	batch.Notify("notify", "again")
+	if err := batch.Do(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-notified
+
+	// A batch rejected as a whole returns the server's error.
+	h.MaxBatchSize = 1
+	batch = c.NewBatch()
+	batch.Add("Echoer.Echo", "a", nil)
+	batch.Add("Echoer.Echo", "b", nil)
+	if err := batch.Do(ctx); !errors.As(err, &e) || e.Code != StatusInvalidRequest {
+		t.Fatalf("expected invalid request, got %v", err)
+	}
+}
+
+func TestClientDeadline(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethod("deadline", func(ctx context.Context) bool {
+		_, ok := ctx.Deadline()
+		return ok
+	})
+	h.RegisterMethod("block", func(ctx context.Context) {
+		<-ctx.Done()
+	})
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	c := NewClient(srv.URL, srv.Client())
+
+	// The deadline is sent to the server.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var ok bool
+	if err := c.Call(ctx, "deadline", nil, &ok); err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected the method to have a deadline")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := c.Call(ctx, "block", nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("call was not abandoned promptly: took %v", d)
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		t.Fatalf("expected a context error, got %v", err)
+	}
+}
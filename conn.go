@@ -0,0 +1,397 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrConnClosed is returned when sending on a Conn that is no longer being
+// served.
+var ErrConnClosed = errors.New("jsonrpc: connection closed")
+
+// Conn is a connection served by a Handler over a bi-directional stream. It
+// can be used to send notifications to the client, which allows the server to
+// push events, and to shut the connection down gracefully.
+//
+// Methods called over the connection can obtain it using ConnFromContext. A
+// Conn is safe for concurrent use, and may be kept after the method returns.
+type Conn struct {
+	h  *Handler
+	rw io.ReadWriter
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	enc      Encoder
+	cancel   context.CancelFunc
+	closed   bool
+	shutdown bool
+	draining chan struct{}
+	warned   map[string]bool
+}
+
+// NewConn returns a Conn that serves JSON-RPC over rw once Serve is called.
+func (h *Handler) NewConn(rw io.ReadWriter) *Conn {
+	c := &Conn{h: h, rw: rw, cancel: func() {}, draining: make(chan struct{})}
+	if h.DirectConnWrites {
+		c.enc = h.newEncoder(rw)
+	} else {
+		c.enc = h.newEncoder(&c.buf)
+	}
+	return c
+}
+
+// Serve reads requests from the stream and calls their methods, each in its
+// own goroutine. It returns once the stream is exhausted or the Conn is shut
+// down, and all calls have finished.
+func (c *Conn) Serve(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c.mu.Lock()
+	c.cancel = cancel
+	stopped := c.shutdown || c.closed
+	c.mu.Unlock()
+	defer c.close()
+	if stopped {
+		// The Conn was shut down before it could be canceled.
+		return
+	}
+	ctx = context.WithValue(ctx, connKey, c)
+
+	h := c.h
+	cr := newConnReader(ctx, c.rw)
+	defer cr.close()
+	r := bufio.NewReader(cr)
+	skipBOM(r)
+	dec := h.newDecoder(r)
+	send := func(req *request) {
+		h.log(req, c.write(h.message(ctx, req)))
+	}
+
+	// Limit the number of methods executing at once.
+	var sem chan struct{}
+	if h.MaxConcurrency > 0 {
+		sem = make(chan struct{}, h.MaxConcurrency)
+	}
+
+	for {
+		req := getRequest()
+		if !h.decodeRequest(ctx, dec, req) {
+			if req.res.Error != nil && ctx.Err() == nil {
+				// Errors will only occur for parse errors, in which case we
+				// cannot tell if the request was a notification and the client
+				// is not expecting a response. Send the error just to be safe.
+				send(req)
+			}
+			putRequest(req)
+			// No more values are available.
+			c.wg.Wait()
+			return
+		}
+
+		// Start the call in its own goroutine.
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				// The connection is no longer writable.
+				c.wg.Wait()
+				return
+			case <-c.draining:
+				c.wg.Wait()
+				return
+			}
+		}
+		if !c.start() {
+			// The Conn is shutting down, so no new calls are accepted.
+			c.wg.Wait()
+			return
+		}
+		go func() {
+			defer c.wg.Done()
+			defer putRequest(req)
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+
+			h.serve(ctx, req)
+			if req.deprecation != nil && c.warn(req.Method) {
+				c.Notify(DeprecationMethod, req.deprecation)
+			}
+
+			if req.res.ID == nil {
+				h.log(req, nil)
+				return
+			}
+
+			send(req)
+		}()
+	}
+}
+
+// warn reports whether the client should be warned that the named method is
+// deprecated, which is only the first time it is called.
+func (c *Conn) warn(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.warned[name] {
+		return false
+	}
+	if c.warned == nil {
+		c.warned = make(map[string]bool)
+	}
+	c.warned[name] = true
+	return true
+}
+
+// start registers a new call, unless the Conn is shutting down.
+func (c *Conn) start() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.shutdown {
+		return false
+	}
+	c.wg.Add(1)
+	return true
+}
+
+// Shutdown gracefully shuts down the Conn. It stops accepting new requests and
+// waits for calls in progress to finish and send their responses. If ctx
+// expires first, the context of the remaining calls is canceled and Shutdown
+// returns ctx.Err() without waiting further.
+//
+// Once Shutdown returns, no more responses or notifications are written. The
+// caller remains responsible for closing the underlying stream.
+func (c *Conn) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	if !c.shutdown {
+		c.shutdown = true
+		close(c.draining)
+	}
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.mu.Lock()
+	c.closed = true
+	c.cancel()
+	c.mu.Unlock()
+	return err
+}
+
+// ConnFromContext returns the Conn that a method is being called over. It
+// reports false if the method is not being called by ServeConn.
+func ConnFromContext(ctx context.Context) (*Conn, bool) {
+	c, ok := ctx.Value(connKey).(*Conn)
+	return c, ok
+}
+
+type notification struct {
+	Protocol string          `json:"jsonrpc"`
+	Method   string          `json:"method"`
+	Params   json.RawMessage `json:"params,omitempty"`
+}
+
+// Notify sends a notification to the client. Params are sent the same way as
+// Client.Call sends them. Notify returns ErrConnClosed if the connection is no
+// longer being served.
+func (c *Conn) Notify(method string, params interface{}) error {
+	raw, err := marshalParams(params)
+	if err != nil {
+		return err
+	}
+	return c.write(notification{Protocol: "2.0", Method: method, Params: raw})
+}
+
+// StreamMethod is the method of the notifications sent by Stream.
+const StreamMethod = "rpc.stream"
+
+// ErrNotStreaming is returned by Stream when the method is not being called
+// over a Conn, or is being called for a notification.
+var ErrNotStreaming = errors.New("jsonrpc: cannot stream without a connection and a request ID")
+
+// StreamParams are the params of the notifications sent by Stream. The ID is
+// the ID of the request that the result belongs to.
+type StreamParams struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+}
+
+// Stream sends an intermediate result for the request that a method is being
+// called for, before the method returns its final result. The result is sent
+// as a notification of StreamMethod, for example:
+//
+//	{"jsonrpc": "2.0", "method": "rpc.stream", "params": {"id": 1, "result": "line 1"}}
+//
+// Intermediate results are always sent before the response to the request.
+// Stream returns ErrNotStreaming if the method is not being called over a
+// Conn, or the request is a notification.
+func Stream(ctx context.Context, result interface{}) error {
+	c, ok := ConnFromContext(ctx)
+	if !ok {
+		return ErrNotStreaming
+	}
+	id, ok := RequestID(ctx)
+	if !ok {
+		return ErrNotStreaming
+	}
+	raw, err := json.Marshal(StreamParams{ID: id, Result: result})
+	if err != nil {
+		return err
+	}
+	return c.write(notification{Protocol: "2.0", Method: StreamMethod, Params: raw})
+}
+
+// write encodes v as a single write, to help e.g. a websocket adapter send it
+// as one frame. With DirectConnWrites, v is encoded straight to the stream.
+func (c *Conn) write(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrConnClosed
+	}
+
+	err := c.enc.Encode(v)
+	if err == nil && !c.h.DirectConnWrites {
+		_, err = c.buf.WriteTo(c.rw)
+	}
+	c.buf.Reset()
+
+	// If write fails, the writer is no longer valid.
+	if err != nil {
+		c.closed = true
+		c.cancel()
+	}
+	return err
+}
+
+// close prevents any further writes.
+func (c *Conn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+}
+
+// connReader reads the stream of a Conn for Serve, and stops blocking once the
+// context of Serve is done, for example because the connection is no longer
+// writable. If the stream has a SetReadDeadline method, such as a net.Conn,
+// then a deadline in the past interrupts the read, and is cleared once Serve
+// returns. Failing that, a stream that is an io.Closer is closed. Otherwise
+// the stream is read by a single goroutine, which exits once the read in
+// progress returns.
+type connReader struct {
+	ctx  context.Context
+	r    io.Reader
+	stop chan struct{}
+	done chan struct{}
+
+	// reqs and results hand reads to the goroutine when the read cannot be
+	// interrupted.
+	reqs    chan []byte
+	results chan readResult
+	buf     []byte
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+// aLongTimeAgo is a read deadline that has always passed.
+var aLongTimeAgo = time.Unix(1, 0)
+
+func newConnReader(ctx context.Context, r io.Reader) *connReader {
+	cr := &connReader{ctx: ctx, r: r, stop: make(chan struct{}), done: make(chan struct{})}
+	var interrupt, restore func()
+	switch s := r.(type) {
+	case interface{ SetReadDeadline(time.Time) error }:
+		interrupt = func() { s.SetReadDeadline(aLongTimeAgo) }
+		restore = func() { s.SetReadDeadline(time.Time{}) }
+	case io.Closer:
+		interrupt = func() { s.Close() }
+	default:
+		cr.reqs = make(chan []byte)
+		cr.results = make(chan readResult, 1)
+		go cr.readLoop()
+	}
+	go func() {
+		defer close(cr.done)
+		if interrupt == nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			interrupt()
+			<-cr.stop
+			if restore != nil {
+				restore()
+			}
+		case <-cr.stop:
+		}
+	}()
+	return cr
+}
+
+func (r *connReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if r.reqs == nil {
+		n, err := r.r.Read(p)
+		if err != nil && r.ctx.Err() != nil {
+			// The read was interrupted.
+			err = r.ctx.Err()
+		}
+		return n, err
+	}
+
+	// Read into a buffer we own, since the read may outlive this call.
+	if cap(r.buf) < len(p) {
+		r.buf = make([]byte, len(p))
+	}
+	buf := r.buf[:len(p)]
+	r.reqs <- buf
+	select {
+	case res := <-r.results:
+		return copy(p, buf[:res.n]), res.err
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	}
+}
+
+// readLoop performs the reads handed to it, until close is called.
+func (r *connReader) readLoop() {
+	for buf := range r.reqs {
+		n, err := r.r.Read(buf)
+		r.results <- readResult{n, err}
+	}
+}
+
+// close stops the reader once Serve is done with it. An interrupted read
+// deadline is cleared before close returns.
+func (r *connReader) close() {
+	close(r.stop)
+	<-r.done
+	if r.reqs != nil {
+		close(r.reqs)
+	}
+}
@@ -0,0 +1,254 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	canceled := make(chan struct{}, 1)
+	h := NewHandler()
+	h.RegisterMethod("slow", func(ms int) string {
+		started <- struct{}{}
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return "done"
+	})
+	h.RegisterMethod("stuck", func(ctx context.Context) {
+		started <- struct{}{}
+		<-ctx.Done()
+		canceled <- struct{}{}
+	})
+
+	serve := func() (*Conn, *io.PipeWriter, *bytes.Buffer, chan struct{}) {
+		var buf bytes.Buffer
+		pr, pw := io.Pipe()
+		c := h.NewConn(struct {
+			io.Reader
+			io.Writer
+		}{pr, &buf})
+		completion := make(chan struct{})
+		go func() {
+			c.Serve(context.Background())
+			close(completion)
+		}()
+		return c, pw, &buf, completion
+	}
+
+	t.Log("Running shutdown test: drain calls in progress")
+	c, pw, buf, completion := serve()
+	pw.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "slow", "params": [50]}`))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"jsonrpc":"2.0","id":1,"result":"done"}
+`
+	if got := buf.String(); got != expected {
+		t.Fatalf("expected: %s\ngot: %s", expected, got)
+	}
+
+	// New requests are not accepted.
+	pw.Write([]byte(`{"jsonrpc": "2.0", "id": 2, "method": "slow", "params": [0]}`))
+	select {
+	case <-time.NewTimer(time.Second).C:
+		t.Fatal("Serve did not return after shutdown")
+	case <-completion:
+	}
+	pw.Close()
+	if got := buf.String(); got != expected {
+		t.Fatalf("expected: %s\ngot: %s", expected, got)
+	}
+
+	t.Log("Running shutdown test: grace period expires")
+	c, pw, buf, completion = serve()
+	defer pw.Close()
+	pw.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "stuck"}`))
+	<-started
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	select {
+	case <-time.NewTimer(time.Second).C:
+		t.Fatal("call was not canceled")
+	case <-canceled:
+	}
+	if got := buf.String(); got != "" {
+		t.Fatalf("expected no response, got: %s", got)
+	}
+}
+
+func TestConnBrokenStream(t *testing.T) {
+	h := NewHandler(Echoer{})
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	// The stream stays open for reading, but every write fails.
+	go pw.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]}`))
+
+	completion := make(chan struct{})
+	go func() {
+		h.ServeConn(context.Background(), struct {
+			io.Reader
+			io.Writer
+		}{pr, failingWriter{}})
+		close(completion)
+	}()
+
+	select {
+	case <-time.NewTimer(time.Second).C:
+		t.Fatal("ServeConn did not return after a write failure")
+	case <-completion:
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	io.Writer
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestServeConnAndClose(t *testing.T) {
+	var buf bytes.Buffer
+	stream := &closeRecorder{
+		Reader: bytes.NewReader([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]}`)),
+		Writer: &buf,
+	}
+	NewHandler(Echoer{}).ServeConnAndClose(context.Background(), stream)
+	if !stream.closed {
+		t.Fatal("expected the stream to be closed")
+	}
+	expected := `{"jsonrpc":"2.0","id":1,"result":"Hello world!"}
+`
+	if got := buf.String(); got != expected {
+		t.Fatalf("expected: %s\ngot: %s", expected, got)
+	}
+}
+
+func TestDirectConnWrites(t *testing.T) {
+	h := NewHandler(Echoer{})
+	h.DirectConnWrites = true
+
+	t.Log("Running bidirectional test: direct writes")
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]}`))
+			pw.Close()
+		},
+		`{"jsonrpc":"2.0","id":1,"result":"Hello world!"}
+`,
+	)
+}
+
+// brokenConn is a network connection on which every write fails.
+type brokenConn struct {
+	net.Conn
+}
+
+func (brokenConn) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+// blockingStream is a stream whose reads block until it is closed, and on
+// which every write fails.
+type blockingStream struct {
+	failingWriter
+	reading chan struct{}
+	closed  chan struct{}
+}
+
+func (s blockingStream) Read(p []byte) (int, error) {
+	s.reading <- struct{}{}
+	<-s.closed
+	return 0, io.EOF
+}
+
+func (s blockingStream) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestConnInterruptRead(t *testing.T) {
+	h := NewHandler(Echoer{})
+
+	// A read deadline interrupts the read, and is cleared afterwards.
+	server, client := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]}`))
+	completion := make(chan struct{})
+	go func() {
+		h.ServeConn(context.Background(), brokenConn{server})
+		close(completion)
+	}()
+	select {
+	case <-time.NewTimer(time.Second).C:
+		t.Fatal("ServeConn did not return after a write failure")
+	case <-completion:
+	}
+	go client.Write([]byte("x"))
+	if _, err := server.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("expected the read deadline to be cleared: %v", err)
+	}
+
+	// Failing that, a stream that can be closed is closed.
+	s := blockingStream{reading: make(chan struct{}, 1), closed: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	completion = make(chan struct{})
+	go func() {
+		h.ServeConn(ctx, s)
+		close(completion)
+	}()
+	<-s.reading
+	cancel()
+	select {
+	case <-time.NewTimer(time.Second).C:
+		t.Fatal("ServeConn did not return once its context was done")
+	case <-completion:
+	}
+	select {
+	case <-s.closed:
+	default:
+		t.Fatal("expected the stream to be closed")
+	}
+}
+
+func TestConnShutdownBeforeServe(t *testing.T) {
+	h := NewHandler(Echoer{})
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	var buf bytes.Buffer
+	c := h.NewConn(struct {
+		io.Reader
+		io.Writer
+	}{pr, &buf})
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stream is idle, so Serve must not wait to read from it.
+	completion := make(chan struct{})
+	go func() {
+		c.Serve(context.Background())
+		close(completion)
+	}()
+	select {
+	case <-time.NewTimer(time.Second).C:
+		t.Fatal("Serve did not return for a Conn that was already shut down")
+	case <-completion:
+	}
+}
@@ -0,0 +1,111 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+type contextKey int
+
+const (
+	connKey contextKey = iota
+	requestIDKey
+	methodNameKey
+	httpRequestKey
+	responseHeaderKey
+	streamKey
+)
+
+// RequestID returns the ID of the request that a method is being called for,
+// exactly as it was sent by the client. It reports false if the request is a
+// notification.
+func RequestID(ctx context.Context) (json.RawMessage, bool) {
+	id, ok := ctx.Value(requestIDKey).(jsonrpcID)
+	if !ok || id == nil {
+		return nil, false
+	}
+	return json.RawMessage(id), true
+}
+
+// IsNotification reports whether a method is being called for a notification,
+// so that no response will be sent. Hooks such as middleware and the
+// ResponseInterceptor can use it to tell notifications apart from calls. It
+// reports false if the context does not belong to a method call.
+func IsNotification(ctx context.Context) bool {
+	id, ok := ctx.Value(requestIDKey).(jsonrpcID)
+	return ok && id == nil
+}
+
+// MethodName returns the name that a method is being called as. This is useful
+// when the same function is registered under several names. It returns an
+// empty string if the context does not belong to a method call.
+func MethodName(ctx context.Context) string {
+	name, _ := ctx.Value(methodNameKey).(string)
+	return name
+}
+
+// withCall returns a context carrying the ID and method name of the request,
+// for RequestID, IsNotification and MethodName.
+func withCall(ctx context.Context, req *request) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, req.res.ID)
+	return context.WithValue(ctx, methodNameKey, req.Method)
+}
+
+// HTTPRequest returns the HTTP request that a method is being called over,
+// which gives access to its headers, URL and remote address. Its body has
+// already been read and is replaced by http.NoBody. HTTPRequest reports false
+// if the method is not being called by ServeHTTP.
+func HTTPRequest(ctx context.Context) (*http.Request, bool) {
+	r, ok := ctx.Value(httpRequestKey).(*http.Request)
+	return r, ok
+}
+
+// SetResponseHeader sets a header of the HTTP response to the request that a
+// method is being called for, such as Content-Disposition or Cache-Control.
+// Within a batch, every call shares the same response, so the last value set
+// for a key wins.
+//
+// It has no effect if the method is not being called by ServeHTTP, for
+// example under ServeConn, or once the response has been written, such as by
+// a method that keeps running after its MethodTimeout.
+func SetResponseHeader(ctx context.Context, key, value string) {
+	rh, ok := ctx.Value(responseHeaderKey).(*responseHeader)
+	if !ok {
+		return
+	}
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	if rh.written {
+		return
+	}
+	if rh.header == nil {
+		rh.header = make(http.Header)
+	}
+	rh.header.Set(key, value)
+}
+
+// responseHeader collects the headers set by methods for an HTTP response.
+type responseHeader struct {
+	mu      sync.Mutex
+	header  http.Header
+	written bool
+}
+
+// writeResponseHeader copies the headers set by methods called with ctx to w.
+func writeResponseHeader(ctx context.Context, w http.ResponseWriter) {
+	if rh, ok := ctx.Value(responseHeaderKey).(*responseHeader); ok {
+		rh.writeTo(w)
+	}
+}
+
+// writeTo copies the headers to w, after which no more may be set.
+func (rh *responseHeader) writeTo(w http.ResponseWriter) {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	rh.written = true
+	for key, values := range rh.header {
+		w.Header()[key] = values
+	}
+}
@@ -0,0 +1,223 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	ids := make(chan string, 1)
+	h := NewHandler()
+	h.RegisterMethod("id", func(ctx context.Context) json.RawMessage {
+		id, ok := RequestID(ctx)
+		if !ok {
+			ids <- "notification"
+			return nil
+		}
+		ids <- string(id)
+		return id
+	})
+
+	for i, c := range []struct {
+		ID       string
+		Expected string
+	}{
+		{`"id": 1,`, `1`},
+		{`"id": "abc",`, `"abc"`},
+		{`"id": 1.50,`, `1.50`},
+		{`"id": null,`, `null`},
+		{``, `notification`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+			"jsonrpc": "2.0",
+			`+c.ID+`
+			"method": "id"
+		}`))
+		req.Header.Set("Content-Type", "application/json")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		t.Logf("Running test %d", i)
+		if got := <-ids; got != c.Expected {
+			t.Fatalf("expected: %s\ngot: %s", c.Expected, got)
+		}
+	}
+}
+
+func TestMethodName(t *testing.T) {
+	whoami := func(ctx context.Context) string {
+		return MethodName(ctx)
+	}
+	h := NewHandler()
+	h.RegisterMethod("a", whoami)
+	h.RegisterMethod("b", whoami)
+
+	for _, name := range []string{"a", "b"} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "`+name+`"
+		}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		expectJSON(t, w.Body, `{"jsonrpc": "2.0", "id": 1, "result": "`+name+`"}`)
+	}
+
+	if name := MethodName(context.Background()); name != "" {
+		t.Fatalf("expected no method name, got %q", name)
+	}
+}
+
+func TestIsNotification(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	record := func(hook string, ctx context.Context) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, fmt.Sprintf("%s:%v", hook, IsNotification(ctx)))
+	}
+	h := NewHandler()
+	h.MaxConcurrency = 1
+	h.RegisterMethod("record", func(ctx context.Context) {
+		record("method", ctx)
+	})
+	h.ResponseInterceptor = func(ctx context.Context, req Request, res *Response) error {
+		record("interceptor", ctx)
+		return nil
+	}
+	h.Logger = func(entry LogEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, fmt.Sprintf("log:%v", entry.Notification))
+	}
+
+	for i, c := range []struct {
+		ID       string
+		Expected string
+	}{
+		{`"id": 1,`, `method:false interceptor:false log:false`},
+		{`"id": null,`, `method:false interceptor:false log:false`},
+		{``, `method:true interceptor:true log:true`},
+	} {
+		seen = nil
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+			"jsonrpc": "2.0",
+			`+c.ID+`
+			"method": "record"
+		}`))
+		req.Header.Set("Content-Type", "application/json")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		t.Logf("Running test %d", i)
+		if got := strings.Join(seen, " "); got != c.Expected {
+			t.Fatalf("expected: %s\ngot: %s", c.Expected, got)
+		}
+	}
+
+	seen = nil
+	t.Log("Running bidirectional test: notification")
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{"jsonrpc": "2.0", "method": "record"}`))
+			pw.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "record"}`))
+			pw.Close()
+		},
+		`{"jsonrpc":"2.0","id":1,"result":null}
+`,
+	)
+	expected := `method:true interceptor:true log:true method:false interceptor:false log:false`
+	if got := strings.Join(seen, " "); got != expected {
+		t.Fatalf("expected: %s\ngot: %s", expected, got)
+	}
+
+	if IsNotification(context.Background()) {
+		t.Fatal("expected a context without a call not to be a notification")
+	}
+}
+
+func TestHTTPRequest(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethod("tenant", func(ctx context.Context) (string, error) {
+		r, ok := HTTPRequest(ctx)
+		if !ok {
+			return "", errors.New("not an HTTP request")
+		}
+		return r.Header.Get("X-Tenant"), nil
+	})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "tenant"
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Tenant", "acme")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	expectJSON(t, w.Body, `{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"result": "acme"
+	}`)
+
+	// Over a connection there is no HTTP request.
+	var buf bytes.Buffer
+	h.ServeConn(context.Background(), struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "tenant"}`), &buf})
+	expectJSON(t, &buf, `{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"error": {
+			"code": -32603,
+			"message": "not an HTTP request"
+		}
+	}`)
+}
+
+func TestSetResponseHeader(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethod("export", func(ctx context.Context, name string) string {
+		SetResponseHeader(ctx, "Content-Disposition", `attachment; filename="`+name+`"`)
+		SetResponseHeader(ctx, "Cache-Control", "no-store")
+		return name
+	})
+
+	for i, c := range []struct {
+		In          string
+		Disposition string
+	}{
+		{`{"jsonrpc": "2.0", "id": 1, "method": "export", "params": ["a.csv"]}`, `attachment; filename="a.csv"`},
+		{`{"jsonrpc": "2.0", "method": "export", "params": ["b.csv"]}`, `attachment; filename="b.csv"`},
+		{`[{"jsonrpc": "2.0", "id": 1, "method": "export", "params": ["c.csv"]}]`, `attachment; filename="c.csv"`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		if got := w.Header().Get("Content-Disposition"); got != c.Disposition {
+			t.Fatalf("expected Content-Disposition %q, got %q", c.Disposition, got)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "no-store" {
+			t.Fatalf("expected Cache-Control %q, got %q", "no-store", got)
+		}
+	}
+
+	// Under ServeConn, the headers are ignored.
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "export", "params": ["a.csv"]}`))
+			pw.Close()
+		},
+		`{"jsonrpc":"2.0","id":1,"result":"a.csv"}
+`,
+	)
+}
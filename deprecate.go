@@ -0,0 +1,68 @@
+package jsonrpc
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// DeprecationMethod is the method of the notification sent over a Conn the
+// first time a deprecated method is called on it.
+const DeprecationMethod = "rpc.deprecated"
+
+// DeprecationParams are the params of the notification sent by ServeConn when
+// a deprecated method is called.
+type DeprecationParams struct {
+	Method      string `json:"method"`
+	Replacement string `json:"replacement,omitempty"`
+	Message     string `json:"message"`
+}
+
+// Deprecate marks the named method as deprecated in favor of replacement,
+// which may be empty. Calls to the method still succeed, but are reported as
+// deprecated to the Logger, and the client is warned:
+//
+//   - over HTTP, with a Warning header on the response
+//   - over ServeConn, with a notification of DeprecationMethod, sent once per
+//     connection before the first response
+//
+// Deprecated methods are also marked as such by OpenRPC and introspection.
+func (h *Handler) Deprecate(name, replacement string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.deprecated == nil {
+		h.deprecated = make(map[string]string)
+	}
+	h.deprecated[name] = replacement
+}
+
+// deprecation returns the params of the deprecation warning for the named
+// method, or nil if the method is not deprecated.
+func (h *Handler) deprecation(name string) *DeprecationParams {
+	h.mu.RLock()
+	replacement, ok := h.deprecated[name]
+	if !ok {
+		replacement, ok = h.deprecated[h.resolve(name)]
+	}
+	h.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	d := &DeprecationParams{
+		Method:      name,
+		Replacement: replacement,
+		Message:     fmt.Sprintf("Method %s is deprecated", name),
+	}
+	if replacement != "" {
+		d.Message += fmt.Sprintf("; use %s instead", replacement)
+	}
+	return d
+}
+
+// warnDeprecated adds a Warning header for each deprecated request.
+func warnDeprecated(w http.ResponseWriter, reqs ...*request) {
+	for _, req := range reqs {
+		if req.deprecation != nil {
+			w.Header().Add("Warning", fmt.Sprintf("299 - %q", req.deprecation.Message))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package jsonrpc
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeprecate(t *testing.T) {
+	var deprecated []bool
+	h := NewHandler()
+	h.Logger = func(entry LogEntry) {
+		deprecated = append(deprecated, entry.Deprecated)
+	}
+	getUser := func(id int) string {
+		return "user"
+	}
+	h.RegisterMethod("v1.getUser", getUser)
+	h.RegisterMethod("v2.getUser", getUser)
+	h.Deprecate("v1.getUser", "v2.getUser")
+
+	for i, c := range []struct {
+		Method  string
+		Warning string
+	}{
+		{"v1.getUser", `299 - "Method v1.getUser is deprecated; use v2.getUser instead"`},
+		{"v2.getUser", ""},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "`+c.Method+`",
+			"params": [1]
+		}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, `{"jsonrpc": "2.0", "id": 1, "result": "user"}`)
+		if got := w.Header().Get("Warning"); got != c.Warning {
+			t.Fatalf("expected Warning %q, got %q", c.Warning, got)
+		}
+	}
+	if len(deprecated) != 2 || !deprecated[0] || deprecated[1] {
+		t.Fatalf("unexpected log entries: %v", deprecated)
+	}
+
+	// Connections are warned once.
+	h.Logger = nil
+	h.MaxConcurrency = 1
+	t.Log("Running bidirectional test: deprecation notification")
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "v1.getUser", "params": [1]}`))
+			pw.Write([]byte(`{"jsonrpc": "2.0", "id": 2, "method": "v1.getUser", "params": [2]}`))
+			pw.Close()
+		},
+		`{"jsonrpc":"2.0","method":"rpc.deprecated","params":{"method":"v1.getUser","replacement":"v2.getUser","message":"Method v1.getUser is deprecated; use v2.getUser instead"}}
+{"jsonrpc":"2.0","id":1,"result":"user"}
+{"jsonrpc":"2.0","id":2,"result":"user"}
+`,
+	)
+}
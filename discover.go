@@ -0,0 +1,109 @@
+package jsonrpc
+
+import "sort"
+
+// IntrospectionMethod is the default method name registered by
+// EnableIntrospection.
+const IntrospectionMethod = "system.listMethods"
+
+// MethodInfo describes a registered method.
+type MethodInfo struct {
+	Name     string `json:"name"`
+	Params   int    `json:"params"`   // Number of params, not counting a variadic param.
+	Variadic bool   `json:"variadic"` // Whether the method accepts any number of additional params.
+	Context  bool   `json:"context"`  // Whether the method receives a context.Context.
+	Results  int    `json:"results"`  // Number of results, not counting an error.
+	Error    bool   `json:"error"`    // Whether the method returns an error.
+
+	// Raw is set for a raw method, which receives its params as sent, so they
+	// may be any value. Params is then 0.
+	Raw bool `json:"raw,omitempty"`
+
+	AliasOf    string `json:"aliasOf,omitempty"`    // The method this is an alias of, from Alias.
+	Summary    string `json:"summary,omitempty"`    // From RegisterMethodWithMeta.
+	Deprecated bool   `json:"deprecated,omitempty"` // From RegisterMethodWithMeta or Deprecate.
+}
+
+func (m *method) info(name string) MethodInfo {
+	info := MethodInfo{
+		Name:     name,
+		Params:   m.nargs,
+		Variadic: m.variadic != nil,
+		Context:  m.hasContext,
+		Results:  m.nresults,
+		Error:    m.hasError,
+
+		Summary:    m.meta.Summary,
+		Deprecated: m.meta.Deprecated,
+	}
+	if m.raw != nil {
+		// The params are not counted, so there is no fixed number of them.
+		info.Params = 0
+		info.Raw = true
+	}
+	return info
+}
+
+// EnableIntrospection registers a method that lists every registered method,
+// sorted by name, as an array of MethodInfo. If name is empty, then
+// IntrospectionMethod is used.
+//
+// Introspection is opt-in, since it reveals every method to clients.
+func (h *Handler) EnableIntrospection(name string) {
+	if name == "" {
+		name = IntrospectionMethod
+	}
+	h.RegisterMethod(name, h.listMethods)
+}
+
+// Methods returns the names of every registered method, sorted.
+func (h *Handler) Methods() []string {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.registry))
+	for name := range h.registry {
+		names = append(names, name)
+	}
+	h.mu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Method describes the method registered under the given name. It reports
+// false if there is no such method.
+func (h *Handler) Method(name string) (MethodInfo, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	m, ok := h.registry[name]
+	if !ok {
+		return MethodInfo{}, false
+	}
+	return h.methodInfo(name, m), true
+}
+
+// methodInfo describes a registered method, including what was configured on
+// the Handler for it. h.mu must be held.
+func (h *Handler) methodInfo(name string, m *method) MethodInfo {
+	info := m.info(name)
+	if _, ok := h.deprecated[name]; ok {
+		info.Deprecated = true
+	} else if _, ok := h.deprecated[h.resolve(name)]; ok {
+		info.Deprecated = true
+	}
+	info.AliasOf = h.aliases[name]
+	return info
+}
+
+func (h *Handler) listMethods() []MethodInfo {
+	h.mu.RLock()
+	infos := make([]MethodInfo, 0, len(h.registry))
+	for name, m := range h.registry {
+		infos = append(infos, h.methodInfo(name, m))
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
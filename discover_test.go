@@ -0,0 +1,128 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIntrospection(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.RegisterMethod("ctx", func(ctx context.Context, s ...string) error { return nil })
+	h.RegisterMethod("proxy", func(ctx context.Context, params json.RawMessage) (json.RawMessage, error) {
+		return params, nil
+	})
+	h.EnableIntrospection("")
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "system.listMethods"
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	expectJSON(t, w.Body, `{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"result": [
+			{"name": "Echoer.DelayEcho", "params": 2, "variadic": false, "context": false, "results": 1, "error": false},
+			{"name": "Echoer.Echo", "params": 1, "variadic": false, "context": false, "results": 1, "error": false},
+			{"name": "ctx", "params": 0, "variadic": true, "context": true, "results": 0, "error": true},
+			{"name": "proxy", "params": 0, "variadic": false, "context": true, "results": 1, "error": true, "raw": true},
+			{"name": "system.listMethods", "params": 0, "variadic": false, "context": false, "results": 1, "error": false}
+		]
+	}`)
+}
+
+func TestMethods(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.RegisterMethod("ctx", func(ctx context.Context, s ...string) error { return nil })
+	h.Deprecate("Echoer.Echo", "ctx")
+
+	names := h.Methods()
+	if !reflect.DeepEqual(names, []string{"Echoer.DelayEcho", "Echoer.Echo", "ctx"}) {
+		t.Fatalf("unexpected methods: %v", names)
+	}
+	names[0] = "changed"
+	if h.Methods()[0] != "Echoer.DelayEcho" {
+		t.Fatal("Methods did not return a copy")
+	}
+
+	info, ok := h.Method("ctx")
+	if !ok {
+		t.Fatal("expected method ctx")
+	}
+	expected := MethodInfo{Name: "ctx", Variadic: true, Context: true, Error: true}
+	if info != expected {
+		t.Fatalf("expected: %+v\ngot: %+v", expected, info)
+	}
+	if info, _ := h.Method("Echoer.Echo"); !info.Deprecated {
+		t.Fatal("expected Echoer.Echo to be deprecated")
+	}
+	if _, ok := h.Method("unknown"); ok {
+		t.Fatal("unexpected method unknown")
+	}
+}
+
+func TestAlias(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	if err := h.Alias("echo", "Echoer.Echo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Alias("missing", "unknown"); err == nil {
+		t.Fatal("expected error aliasing an unknown method")
+	}
+	if err := h.Alias("echo", "Echoer.DelayEcho"); err == nil {
+		t.Fatal("expected error aliasing over an existing method")
+	}
+	h.EnableIntrospection("")
+
+	for i, c := range []struct {
+		In  string
+		Out string
+	}{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "echo",
+			"params": ["Hello world!"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": "Hello world!"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "system.listMethods"
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"result": [
+				{"name": "Echoer.DelayEcho", "params": 2, "variadic": false, "context": false, "results": 1, "error": false},
+				{"name": "Echoer.Echo", "params": 1, "variadic": false, "context": false, "results": 1, "error": false},
+				{"name": "echo", "params": 1, "variadic": false, "context": false, "results": 1, "error": false, "aliasOf": "Echoer.Echo"},
+				{"name": "system.listMethods", "params": 0, "variadic": false, "context": false, "results": 1, "error": false}
+			]
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+
+	// Registering over an alias replaces it.
+	h.RegisterMethod("echo", func(s string) string { return s })
+	for _, info := range h.listMethods() {
+		if info.Name == "echo" && info.AliasOf != "" {
+			t.Fatalf("expected echo to no longer be an alias, got %+v", info)
+		}
+	}
+}
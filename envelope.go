@@ -0,0 +1,147 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// EnvelopeCodec maps requests and responses between the messages sent over a
+// transport and the JSON-RPC envelope. It lets the Handler dispatch messages
+// that wrap calls under other field names, such as {"cmd": ..., "args": ...}.
+//
+// Batches are still JSON arrays, and each of their elements is decoded by the
+// EnvelopeCodec on its own. Requests in the query string of a GET request,
+// and notifications sent over a Conn, are not affected.
+type EnvelopeCodec interface {
+	// DecodeRequest decodes the next request from dec. It returns io.EOF when
+	// no more requests are available. A *json.SyntaxError is sent to the
+	// client as a parse error, and any other error as an invalid request.
+	DecodeRequest(dec Decoder) (RequestEnvelope, error)
+
+	// EncodeResponse returns the value to encode for a response.
+	EncodeResponse(res ResponseEnvelope) interface{}
+}
+
+// RequestEnvelope is a request decoded by an EnvelopeCodec. The Protocol must
+// be "2.0" for the request to be accepted, unless AllowV1 is set. If the ID is
+// nil, the request is a notification.
+type RequestEnvelope struct {
+	Protocol string
+	ID       json.RawMessage
+	Method   string
+	Params   json.RawMessage
+}
+
+// ResponseEnvelope is a response to be encoded by an EnvelopeCodec. Either the
+// Result or the Error is set. The ID is null if the request could not be read.
+type ResponseEnvelope struct {
+	ID     json.RawMessage
+	Result interface{}
+	Error  *Error
+}
+
+// decodeEnvelope decodes a request using the EnvelopeCodec.
+func (h *Handler) decodeEnvelope(dec Decoder, req *request) error {
+	env, err := h.Envelope.DecodeRequest(dec)
+	if err != nil {
+		return err
+	}
+	req.Protocol = env.Protocol
+	req.Method = env.Method
+	req.Params = env.Params
+	if env.ID != nil {
+		return req.ID.UnmarshalJSON(env.ID)
+	}
+	return nil
+}
+
+// message returns the value to be encoded for the response to req, using the
+// EnvelopeCodec if there is one, and adding the members returned by the
+// ResponseDecorator.
+func (h *Handler) message(ctx context.Context, req *request) interface{} {
+	res := &req.res
+	var msg interface{}
+	if h.Envelope == nil {
+		msg = res.message()
+	} else {
+		env := ResponseEnvelope{ID: json.RawMessage(res.ID), Error: res.Error}
+		if res.Error == nil {
+			env.Result = res.Result
+		}
+		msg = h.Envelope.EncodeResponse(env)
+	}
+
+	if h.ResponseDecorator == nil {
+		return msg
+	}
+	message := Response{Error: res.Error}
+	if res.Error == nil {
+		message.Result = res.Result
+	}
+	fields := h.ResponseDecorator(withCall(ctx, req), Request{Method: req.Method, Params: req.Params}, message)
+	if len(fields) == 0 {
+		return msg
+	}
+	return decoratedMessage{msg: msg, fields: fields, newEncoder: h.newEncoder}
+}
+
+// decoratedMessage is a response with extra members added to its top-level
+// object. The response and the members are encoded by the Handler's encoder.
+type decoratedMessage struct {
+	msg        interface{}
+	fields     map[string]interface{}
+	newEncoder func(w io.Writer) Encoder
+}
+
+func (d decoratedMessage) MarshalJSON() ([]byte, error) {
+	b, err := d.marshal(d.msg)
+	if err != nil {
+		return nil, err
+	}
+	var members map[string]json.RawMessage
+	if json.Unmarshal(b, &members) != nil || members == nil {
+		// Only an object can have members added.
+		return b, nil
+	}
+
+	names := make([]string, 0, len(d.fields))
+	for name := range d.fields {
+		if _, ok := members[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// Insert the members before the closing brace, and any indentation
+	// before it.
+	b = bytes.TrimRight(b[:len(b)-1], " \t\r\n")
+	for _, name := range names {
+		k, err := d.marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.marshal(d.fields[name])
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > 1 {
+			b = append(b, ',')
+		}
+		b = append(b, k...)
+		b = append(b, ':')
+		b = append(b, v...)
+	}
+	return append(b, '}'), nil
+}
+
+// marshal encodes v without a trailing newline.
+func (d decoratedMessage) marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := d.newEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
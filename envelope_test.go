@@ -0,0 +1,156 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// commandCodec wraps calls as {"seq": ..., "cmd": ..., "args": ...}.
+type commandCodec struct{}
+
+func (commandCodec) DecodeRequest(dec Decoder) (RequestEnvelope, error) {
+	var msg struct {
+		Seq  json.RawMessage `json:"seq"`
+		Cmd  string          `json:"cmd"`
+		Args json.RawMessage `json:"args"`
+	}
+	if err := dec.Decode(&msg); err != nil {
+		return RequestEnvelope{}, err
+	}
+	return RequestEnvelope{Protocol: "2.0", ID: msg.Seq, Method: msg.Cmd, Params: msg.Args}, nil
+}
+
+func (commandCodec) EncodeResponse(res ResponseEnvelope) interface{} {
+	msg := map[string]interface{}{"seq": res.ID}
+	if res.Error != nil {
+		msg["fail"] = res.Error.Message
+	} else {
+		msg["ok"] = res.Result
+	}
+	return msg
+}
+
+func TestEnvelope(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.Envelope = commandCodec{}
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{"seq": 1, "cmd": "Echoer.Echo", "args": ["Hello world!"]}`, `{"ok": "Hello world!", "seq": 1}`},
+		{`{"seq": 2, "cmd": "unknown"}`, `{"fail": "No such method: unknown", "seq": 2}`},
+		{`{"seq": {}, "cmd": "Echoer.Echo"}`, `{"fail": "\"id\" is not a valid type: {}", "seq": null}`},
+		{`[{"seq": 3, "cmd": "Echoer.Echo", "args": ["a"]}, {"cmd": "Echoer.Echo", "args": ["b"]}]`, `[{"ok": "a", "seq": 3}]`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+
+	t.Log("Running bidirectional test: custom envelope")
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{"seq": "a", "cmd": "Echoer.Echo", "args": ["Hello world!"]}`))
+			pw.Close()
+		},
+		`{"ok":"Hello world!","seq":"a"}
+`,
+	)
+}
+
+func TestResponseDecorator(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.ResponseDecorator = func(ctx context.Context, req Request, res Response) map[string]interface{} {
+		if req.Method == "Echoer.Echo" && res.Result == "quiet" {
+			return nil
+		}
+		meta := map[string]interface{}{"method": req.Method, "failed": res.Error != nil}
+		return map[string]interface{}{"meta": meta, "id": "replaced"}
+	}
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]}`,
+			`{"jsonrpc": "2.0", "id": 1, "result": "Hello world!", "meta": {"failed": false, "method": "Echoer.Echo"}}`},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["quiet"]}`,
+			`{"jsonrpc": "2.0", "id": 1, "result": "quiet"}`},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "unknown"}`,
+			`{"jsonrpc": "2.0", "id": 1, "error": {"code": -32601, "message": "No such method: unknown"}, "meta": {"failed": true, "method": "unknown"}}`},
+		{`{"jsonrpc": "2.0", "id": 1`,
+			`{"jsonrpc": "2.0", "id": null, "error": {"code": -32600, "message": "unexpected EOF"}, "meta": {"failed": true, "method": ""}}`},
+		{`[{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["a"]}]`,
+			`[{"jsonrpc": "2.0", "id": 1, "result": "a", "meta": {"failed": false, "method": "Echoer.Echo"}}]`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+
+	h.DisableHTMLEscaping = true
+	t.Log("Running bidirectional test: decorated response")
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["<b>"]}`))
+			pw.Close()
+		},
+		`{"jsonrpc":"2.0","id":1,"result":"<b>","meta":{"failed":false,"method":"Echoer.Echo"}}
+`,
+	)
+	h.DisableHTMLEscaping = false
+	h.Indent = "  "
+	t.Log("Running bidirectional test: indented decorated response")
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["a"]}`))
+			pw.Close()
+		},
+		`{
+  "jsonrpc": "2.0",
+  "id": 1,
+  "result": "a",
+  "meta": {
+    "failed": false,
+    "method": "Echoer.Echo"
+  }
+}
+`,
+	)
+
+	// The members are encoded by the Encoder, which here does not escape
+	// HTML characters.
+	h.Indent = ""
+	h.Encoder = func(w io.Writer) Encoder {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return enc
+	}
+	h.ResponseDecorator = func(ctx context.Context, req Request, res Response) map[string]interface{} {
+		return map[string]interface{}{"meta": "<i>"}
+	}
+	t.Log("Running bidirectional test: decorated response with Encoder")
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["<b>"]}`))
+			pw.Close()
+		},
+		`{"jsonrpc":"2.0","id":1,"result":"<b>","meta":"<i>"}
+`,
+	)
+}
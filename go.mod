@@ -1,5 +1,14 @@
 module github.com/chowey/jsonrpc
 
-go 1.13
+go 1.20
 
-require github.com/helloeave/json v1.13.0
+require (
+	github.com/gorilla/websocket v1.5.0
+	github.com/helloeave/json v1.13.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	golang.org/x/time v0.0.0-20220210224613-90d013bbcef8
+)
+
+require golang.org/x/sys v0.0.0-20210423185535-09eb48e85fd7 // indirect
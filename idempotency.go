@@ -0,0 +1,95 @@
+package jsonrpc
+
+import "context"
+
+// IdempotencyHeader is the HTTP request header holding the idempotency key of
+// a request. Over ServeConn, or within a batch, the key is instead sent as the
+// "idempotencyKey" member of the request object.
+const IdempotencyHeader = "Idempotency-Key"
+
+// IdempotencyStore records the responses of calls made with an idempotency
+// key, so that a retried call can be answered without calling the method
+// again. It must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Load returns the response recorded for the method and key, if any.
+	Load(ctx context.Context, method, key string) (Response, bool)
+
+	// Store records the response of a call to the method with the key.
+	Store(ctx context.Context, method, key string, res Response)
+}
+
+// Idempotent makes the named methods honor idempotency keys, using the
+// IdempotencyStore of the Handler. The first call with a given key calls the
+// method and records its response, including an error, and later calls with
+// the same key receive that response without calling the method again. Calls
+// without a key, and calls to other methods, are unaffected.
+//
+// Calls with the same key that arrive while the first is still in progress
+// wait for it to finish. This only holds within a single Handler, so a store
+// shared by several servers should also reserve keys itself if calls must be
+// made at most once.
+func (h *Handler) Idempotent(names ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.idempotent == nil {
+		h.idempotent = make(map[string]bool)
+	}
+	for _, name := range names {
+		h.idempotent[name] = true
+	}
+}
+
+// idempotencyKey identifies calls that must be made at most once.
+type idempotencyKey struct {
+	method, key string
+}
+
+// callOnce calls the method for the request, unless its idempotency key shows
+// that it has already been called, in which case the recorded response is
+// used instead.
+func (h *Handler) callOnce(ctx context.Context, req *request) {
+	store := h.IdempotencyStore
+	k := idempotencyKey{req.Method, req.IdempotencyKey}
+	h.mu.RLock()
+	idempotent := h.idempotent[k.method] || h.idempotent[h.resolve(k.method)]
+	h.mu.RUnlock()
+	if store == nil || k.key == "" || !idempotent {
+		h.call(ctx, req)
+		return
+	}
+
+	// Wait for a call in progress with the same key.
+	for {
+		h.mu.Lock()
+		wait, busy := h.pending[k]
+		if !busy {
+			if h.pending == nil {
+				h.pending = make(map[idempotencyKey]chan struct{})
+			}
+			h.pending[k] = make(chan struct{})
+			h.mu.Unlock()
+			break
+		}
+		h.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			req.res.Error = h.mapError(ctx.Err())
+			return
+		}
+	}
+	defer func() {
+		h.mu.Lock()
+		close(h.pending[k])
+		delete(h.pending, k)
+		h.mu.Unlock()
+	}()
+
+	if res, ok := store.Load(ctx, k.method, k.key); ok {
+		req.res.Result, req.res.Error = res.Result, res.Error
+		return
+	}
+	h.call(ctx, req)
+	store.Store(ctx, k.method, k.key, Response{Result: req.res.Result, Error: req.res.Error})
+}
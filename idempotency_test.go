@@ -0,0 +1,136 @@
+package jsonrpc
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mapStore is an IdempotencyStore that keeps responses in memory.
+type mapStore struct {
+	mu        sync.Mutex
+	responses map[string]Response
+}
+
+func (s *mapStore) Load(ctx context.Context, method, key string) (Response, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, ok := s.responses[method+" "+key]
+	return res, ok
+}
+
+func (s *mapStore) Store(ctx context.Context, method, key string, res Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.responses == nil {
+		s.responses = make(map[string]Response)
+	}
+	s.responses[method+" "+key] = res
+}
+
+func TestIdempotent(t *testing.T) {
+	var mu sync.Mutex
+	var charges int
+	charge := func(amount int) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if amount <= 0 {
+			return 0, &Error{Code: StatusInvalidParams, Message: "Invalid amount"}
+		}
+		charges++
+		return charges, nil
+	}
+
+	h := NewHandler()
+	h.IdempotencyStore = &mapStore{}
+	h.RegisterMethod("charge", charge)
+	h.RegisterMethod("count", charge)
+	h.Idempotent("charge")
+
+	// Prepare test cases.
+	type compare struct {
+		Key string
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{"a", `{"jsonrpc": "2.0", "id": 1, "method": "charge", "params": [5]}`,
+			`{"jsonrpc": "2.0", "id": 1, "result": 1}`},
+		{"a", `{"jsonrpc": "2.0", "id": 2, "method": "charge", "params": [5]}`,
+			`{"jsonrpc": "2.0", "id": 2, "result": 1}`},
+		{"b", `{"jsonrpc": "2.0", "id": 3, "method": "charge", "params": [5]}`,
+			`{"jsonrpc": "2.0", "id": 3, "result": 2}`},
+		{"", `{"jsonrpc": "2.0", "id": 4, "method": "charge", "params": [5]}`,
+			`{"jsonrpc": "2.0", "id": 4, "result": 3}`},
+		{"", `{"jsonrpc": "2.0", "id": 5, "method": "charge", "params": [5], "idempotencyKey": "a"}`,
+			`{"jsonrpc": "2.0", "id": 5, "result": 1}`},
+		{"c", `{"jsonrpc": "2.0", "id": 6, "method": "charge", "params": [0]}`,
+			`{"jsonrpc": "2.0", "id": 6, "error": {"code": -32602, "message": "Invalid amount"}}`},
+		{"c", `{"jsonrpc": "2.0", "id": 7, "method": "charge", "params": [5]}`,
+			`{"jsonrpc": "2.0", "id": 7, "error": {"code": -32602, "message": "Invalid amount"}}`},
+		{"a", `{"jsonrpc": "2.0", "id": 8, "method": "count", "params": [5]}`,
+			`{"jsonrpc": "2.0", "id": 8, "result": 4}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		if c.Key != "" {
+			req.Header.Set(IdempotencyHeader, c.Key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+
+	t.Log("Running bidirectional test: idempotency key member")
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "charge", "params": [5], "idempotencyKey": "b"}`))
+			pw.Close()
+		},
+		`{"jsonrpc":"2.0","id":1,"result":2}
+`,
+	)
+}
+
+func TestIdempotentConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	release := make(chan struct{})
+	h := NewHandler()
+	h.IdempotencyStore = &mapStore{}
+	h.RegisterMethod("once", func() int {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return calls
+	})
+	h.Idempotent("once")
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 3)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "once"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(IdempotencyHeader, "key")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			bodies[i] = w.Body.String()
+		}(i)
+	}
+	close(release)
+	wg.Wait()
+
+	for i, body := range bodies {
+		if body != `{"jsonrpc":"2.0","id":1,"result":1}`+"\n" {
+			t.Fatalf("response %d: unexpected %s", i, body)
+		}
+	}
+}
@@ -7,8 +7,9 @@ follows:
 
 	- the first parameter may be a context.Context
 	- the remaining parameters must be able to unmarshal from JSON
-	- return values must be (optionally) a value and (optionally) an error
-	- if there is a return value, it must be able to marshal as JSON
+	- return values must be (optionally) values and (optionally) an error
+	- return values must be able to marshal as JSON; multiple values are
+	  sent as an array
 
 Here is a simple example of a JSON-RPC 2.0 command that echos its input:
 
@@ -51,15 +52,28 @@ NewHandler constructor. For example:
 package jsonrpc
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"os"
 	"reflect"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/time/rate"
 )
 
 // JSON-RPC 2.0 reserved status codes.
@@ -71,6 +85,36 @@ const (
 	StatusInternalError  = -32603 // Internal JSON-RPC error.
 )
 
+// Server error codes used by this package, from the range reserved for
+// implementation-defined server errors.
+const (
+	StatusRateLimited      = -32000 // The method has been called too often.
+	StatusUnauthorized     = -32001 // The caller is not authorized to call the method.
+	StatusBatchAborted     = -32002 // The method was not called because its batch was aborted.
+	StatusCancelled        = -32003 // The method returned context.Canceled.
+	StatusDeadlineExceeded = -32004 // The method returned context.DeadlineExceeded.
+)
+
+// ErrMethodNotFound is the cause of the error sent when no method is
+// registered under the requested name, so that hooks such as OnCallEnd and
+// Logger can detect it using errors.Is. A Fallback may also return it to
+// decline a method.
+var ErrMethodNotFound = errors.New("jsonrpc: method not found")
+
+// methodNotFound returns the error sent when the named method does not exist.
+func methodNotFound(name string) *Error {
+	return &Error{
+		Code:     StatusMethodNotFound,
+		Message:  fmt.Sprintf("No such method: %s", name),
+		original: ErrMethodNotFound,
+	}
+}
+
+// TimeoutHeader is an optional HTTP request header that sets a deadline on the
+// context passed to methods. Its value is parsed with time.ParseDuration, for
+// example "500ms". Invalid values are ignored.
+const TimeoutHeader = "X-JSONRPC-Timeout"
+
 // Request is unmarshalled before every JSON-RPC call. It contains the raw
 // message and params from the JSON-RPC message.
 type Request struct {
@@ -94,24 +138,36 @@ func (m jsonrpcID) MarshalJSON() ([]byte, error) {
 	return m, nil
 }
 
+// invalidIDError is returned when a request ID is not a string, number or
+// null. The rest of the request has still been read.
+type invalidIDError struct {
+	id []byte
+}
+
+func (err *invalidIDError) Error() string {
+	return fmt.Sprintf("\"id\" is not a valid type: %s", err.id)
+}
+
 func (m *jsonrpcID) UnmarshalJSON(data []byte) error {
 	if m == nil {
 		return errors.New("id: UnmarshalJSON on nil pointer")
 	}
 
-	// Verify that data is either a string or a number.
+	// Verify that data is either a string or a number. Numbers are not
+	// converted, so that large integers are echoed exactly.
 	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
 	tok, err := dec.Token()
 	if err != nil {
 		return err
 	}
 	switch tok.(type) {
 	case string:
-	case float64:
+	case json.Number:
 	case nil:
 	default:
 		// Other types are not allowed for JSON-RPC IDs.
-		return fmt.Errorf("\"id\" is not a valid type: %s", data)
+		return &invalidIDError{id: data}
 	}
 
 	*m = append((*m)[0:0], data...)
@@ -124,32 +180,85 @@ type request struct {
 	Method   string          `json:"method"`
 	Params   json.RawMessage `json:"params"`
 
-	res response
-	m   *method
+	// IdempotencyKey is an extension identifying retries of the same call.
+	IdempotencyKey string `json:"idempotencyKey"`
+
+	res         response
+	m           *method
+	duration    time.Duration
+	deprecation *DeprecationParams
+	raw         bool // Whether a Raw result may write the HTTP response.
 }
 
-func (req *request) call(ctx context.Context) {
-	req.res.Protocol = "2.0"
-	req.res.ID = req.ID
+// requestPool holds requests for reuse, which reduces allocations when many
+// requests are served.
+var requestPool = sync.Pool{
+	New: func() interface{} { return new(request) },
+}
 
-	// Call the method.
-	result, err := req.m.call(ctx, req.Params)
-	if err != nil {
-		// Check for pre-existing JSON-RPC errors.
-		if e, ok := err.(*Error); ok && e != nil {
-			req.res.Error = e
-			return
-		}
-		// Create a generic JSON-RPC error.
-		req.res.Error = WrapError(err)
-		return
-	}
-	req.res.Result = result
+func getRequest() *request {
+	return requestPool.Get().(*request)
+}
+
+// putRequest returns a request to the pool. The request must no longer be
+// used, although the values it held may be.
+func putRequest(req *request) {
+	*req = request{}
+	requestPool.Put(req)
+}
+
+// readerPool holds buffered readers for reuse by ServeHTTP.
+var readerPool = sync.Pool{
+	New: func() interface{} { return bufio.NewReader(nil) },
 }
 
 type response struct {
 	errorResponse
 	Result interface{} `json:"result"`
+
+	v1 bool
+}
+
+// v1Response is a JSON-RPC 1.0 response, which always includes both the
+// result and the error, one of them null.
+type v1Response struct {
+	ID     jsonrpcID   `json:"id"`
+	Result interface{} `json:"result"`
+	Error  *Error      `json:"error"`
+}
+
+// message returns the value to be encoded for the response. Error responses
+// must not include a result.
+func (res *response) message() interface{} {
+	if res.v1 {
+		msg := v1Response{ID: res.ID, Error: res.Error}
+		if res.Error == nil {
+			msg.Result = res.Result
+		}
+		return msg
+	}
+	if res.Error != nil {
+		if res.Error.Data != nil && isNil(reflect.ValueOf(res.Error.Data)) {
+			// A typed nil is not omitted by omitempty, so omit it here,
+			// without modifying the Error it came from.
+			e := *res.Error
+			e.Data = nil
+			msg := res.errorResponse
+			msg.Error = &e
+			return msg
+		}
+		return res.errorResponse
+	}
+	return res
+}
+
+// isNil reports whether v holds a nil pointer, map, slice, or similar.
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
 }
 
 type errorResponse struct {
@@ -164,12 +273,21 @@ type Encoder interface {
 	Encode(v interface{}) error
 }
 
+// Decoder is something that can decode from JSON.
+// By default it is a json.Decoder
+type Decoder interface {
+	Decode(v interface{}) error
+}
+
 // Error represents a JSON-RPC 2.0 error. If an Error is returned from a
 // registered function, it will be sent directly to the client.
+//
+// An Error created by the Handler from any other error wraps it, so that
+// errors.Is and errors.As can inspect its cause.
 type Error struct {
 	Code     int         `json:"code"`
 	Message  string      `json:"message"`
-	Data     interface{} `json:"data"`
+	Data     interface{} `json:"data,omitempty"`
 	original error
 }
 
@@ -187,20 +305,89 @@ func WrapError(err error) *Error {
 	}
 }
 
+// NewError creates a JSON-RPC Error with the given code, message and data.
+func NewError(code int, message string, data interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: message,
+		Data:    data,
+	}
+}
+
+// NewServerError creates a JSON-RPC Error with a code from the range reserved
+// for implementation-defined server errors, -32000 to -32099. The code is
+// -32000 minus offset. NewServerError panics if offset is not between 0 and
+// 99.
+func NewServerError(offset int, message string, data interface{}) *Error {
+	if offset < 0 || offset > 99 {
+		panic(fmt.Sprintf("jsonrpc: server error offset %d out of range [0, 99]", offset))
+	}
+	return NewError(-32000-offset, message, data)
+}
+
 func (err *Error) Error() string {
 	return err.Message
 }
 
+// Unwrap returns the error that was converted into this Error, if any.
 func (err *Error) Unwrap() error {
+	// Hooks receive a nil *Error on success, which may still be inspected.
+	if err == nil {
+		return nil
+	}
 	return err.original
 }
 
 // Handler is an http.Handler that responds to JSON-RPC 2.0 requests.
+//
+// Methods may be registered, aliased and unregistered, and middleware added,
+// while the Handler is serving requests. Its fields must not be modified once
+// it is in use.
 type Handler struct {
 	// Encoder configures what encoder will be used for sending JSON-RPC
 	// responses. By default the Handler will use json.NewEncoder.
 	Encoder func(w io.Writer) Encoder
 
+	// IndentPrefix and Indent, if either is specified, pretty-print responses
+	// as json.Encoder.SetIndent does. They only apply to the default encoder,
+	// and are ignored if Encoder is specified.
+	IndentPrefix string
+	Indent       string
+
+	// DisableHTMLEscaping, if true, sends the characters <, > and & in strings
+	// verbatim instead of escaping them, as json.Encoder.SetEscapeHTML(false)
+	// does. It only applies to the default encoder, and is ignored if Encoder
+	// is specified.
+	DisableHTMLEscaping bool
+
+	// Decoder configures what decoder will be used for reading JSON-RPC
+	// requests and for unmarshaling params into method arguments. By default
+	// the Handler will use json.NewDecoder.
+	Decoder func(r io.Reader) Decoder
+
+	// NameMapper, if specified, computes the registered name of each method
+	// when registering a receiver with Register or RegisterName. It receives
+	// the type name and the method name. By default the name follows the
+	// pattern "Type.Method".
+	NameMapper func(typeName, methodName string) string
+
+	// MethodSuggestions, if true, includes the names of up to three similarly
+	// named methods in the Data of method-not-found errors, as SuggestionData.
+	// This helps developers spot typos, but reveals registered methods to
+	// clients, so it is best left off for public services.
+	MethodSuggestions bool
+
+	// DisallowRedefine, if true, makes registering a method under a name that
+	// is already in use an error, so the Try variants return an error and the
+	// others panic. Otherwise the new method replaces the old one.
+	DisallowRedefine bool
+
+	// AllowReservedNames, if true, allows methods to be registered under names
+	// beginning with "rpc.", which JSON-RPC 2.0 reserves for rpc-internal
+	// methods and extensions. Otherwise registering such a name is an error,
+	// so the Try variants return an error and the others panic.
+	AllowReservedNames bool
+
 	// RequestInterceptor, if specified, will be called after the JSON-RPC
 	// message is parsed but before the method is called. The Request may be
 	// modified.
@@ -218,7 +405,272 @@ type Handler struct {
 	// If an error is returned, that error will be sent to the client instead.
 	ResponseInterceptor func(ctx context.Context, req Request, res *Response) error
 
-	registry map[string]*method
+	// ResultTransformer, if specified, will be called with the result of every
+	// method that returns without an error, and its return value is sent to
+	// the client instead. This can be used, for example, to redact fields from
+	// every response in one place.
+	//
+	// If an error is returned, that error will be sent to the client instead.
+	ResultTransformer func(ctx context.Context, method string, result interface{}) (interface{}, error)
+
+	// Fallback, if specified, will be called instead of returning a "method
+	// not found" error when no method is registered under the requested name.
+	// It receives the requested method name and the raw params. The result or
+	// error is sent to the client as if it came from a registered method.
+	//
+	// This can be used, for example, to proxy unknown methods to another
+	// service. Returning ErrMethodNotFound sends the usual "method not found"
+	// error.
+	Fallback func(ctx context.Context, method string, params json.RawMessage) (interface{}, error)
+
+	// ErrorMapper, if specified, will be called when a method returns an error
+	// that is not an *Error. It converts the error into the JSON-RPC error that
+	// is sent to the client. If it returns nil, then the error is sent as
+	// StatusInternalError.
+	//
+	// This can be used, for example, to translate sql.ErrNoRows into an
+	// application-defined error code.
+	ErrorMapper func(err error) *Error
+
+	// MaskInternalErrors, if true, hides the message of unexpected errors from
+	// the client. Errors returned by methods that are not an *Error, and are
+	// not converted by the ErrorMapper, are sent as "Internal error". The same
+	// applies to panics.
+	//
+	// The original error is still available to the ResponseInterceptor by
+	// unwrapping the Response's Error.
+	MaskInternalErrors bool
+
+	// RejectDuplicateKeys, if true, rejects params containing an object with
+	// the same key more than once, at any depth, with StatusInvalidParams.
+	// Otherwise the last value for a key is used.
+	RejectDuplicateKeys bool
+
+	// MaxParamsDepth, if positive, limits how deeply arrays and objects may
+	// be nested within params, counting the params array or object itself as
+	// depth 1. Deeper params are rejected with StatusInvalidRequest before
+	// they are unmarshaled, which protects methods from pathological input.
+	MaxParamsDepth int
+
+	// DisallowUnknownParams, if true, rejects params that contain object keys
+	// which do not match any field of the struct they are unmarshaled into,
+	// with StatusInvalidParams. Otherwise unknown keys are ignored.
+	//
+	// If a custom Decoder is used, it must have a DisallowUnknownFields method
+	// like json.Decoder does.
+	DisallowUnknownParams bool
+
+	// UseNumber, if true, decodes numbers within params as json.Number
+	// wherever they are unmarshaled into an interface{}, such as the elements
+	// of a ...interface{} argument. Otherwise they are float64, which cannot
+	// hold every integer exactly.
+	//
+	// If a custom Decoder is used, it must have a UseNumber method like
+	// json.Decoder does.
+	UseNumber bool
+
+	// StrictParams, if true, requires params to be an array or object, or
+	// omitted, as the spec does. Otherwise a method taking exactly one
+	// non-variadic argument also accepts that argument unwrapped, so that
+	// "params": "Hello world!" is the same as "params": ["Hello world!"].
+	//
+	// Only such unwrapped params change behavior: in strict mode they are
+	// rejected with StatusInvalidParams, including those sent to the
+	// Fallback. Arrays, objects and omitted params behave the same in both
+	// modes.
+	StrictParams bool
+
+	// Envelope, if specified, maps requests and responses to and from the
+	// messages sent over the transport. Otherwise they are standard JSON-RPC
+	// 2.0 messages.
+	Envelope EnvelopeCodec
+
+	// ResponseDecorator, if specified, will be called for every response that
+	// is sent, including error responses, and returns extra members to add to
+	// the top-level response object, such as "meta". Members the response
+	// already has, such as "jsonrpc", "id", "result" and "error", are never
+	// replaced. For a request that could not be read, the Request is empty.
+	// The members are encoded with the Encoder, like the rest of the response.
+	ResponseDecorator func(ctx context.Context, req Request, res Response) map[string]interface{}
+
+	// AllowV1, if true, also accepts JSON-RPC 1.0 requests, which are those
+	// without a "jsonrpc" member. Their responses follow JSON-RPC 1.0 too:
+	// they have no "jsonrpc" member, and include both the result and the
+	// error, one of them null. A request with a null ID is a notification.
+	//
+	// Otherwise only JSON-RPC 2.0 requests are accepted.
+	AllowV1 bool
+
+	// CoerceStringNumbers, if true, accepts a param sent as a JSON string for
+	// an argument that is a number or boolean, such as "123" for an int. The
+	// string is only decoded if the param cannot be decoded as it is. Values
+	// nested within arrays or objects are not coerced.
+	CoerceStringNumbers bool
+
+	// Authorize, if specified, is called before every method registered with
+	// RequireScopes, with the scopes the method requires. Any error rejects
+	// the call with StatusUnauthorized, unless it is already an *Error. The
+	// context gives access to the HTTP request, for example to check a bearer
+	// token.
+	//
+	// Methods without required scopes may be called anonymously.
+	Authorize func(ctx context.Context, method string, scopes []string) error
+
+	// ContextFunc, if specified, is called before every method call to derive
+	// the context passed to the method. It receives the request's context
+	// under ServeHTTP, or the context given to ServeConn, so request-scoped
+	// values such as a database handle or tenant can be attached the same way
+	// for both transports.
+	//
+	// The derived context is also seen by middleware, but not by the
+	// RequestInterceptor or Authorize, which run before it.
+	ContextFunc func(ctx context.Context) context.Context
+
+	// IdempotencyStore, if specified, records the responses of methods made
+	// Idempotent, so that calls retried with the same idempotency key are
+	// not made again.
+	IdempotencyStore IdempotencyStore
+
+	// Logger, if specified, will be called after every request has been
+	// served, including notifications and requests that failed before their
+	// method was called.
+	//
+	// This can be used, for example, to adapt the Handler to a structured
+	// logging library.
+	Logger func(entry LogEntry)
+
+	// OnCallStart and OnCallEnd, if specified, will be called before and after
+	// every request is served. They receive the requested method name, even if
+	// no such method exists. OnCallEnd also receives how long the method took
+	// to run and the error sent to the client, if any. If the method does not
+	// exist, then errors.Is(err, ErrMethodNotFound) reports true.
+	//
+	// This can be used, for example, to collect metrics. To count
+	// notifications separately, use the Notification field of the LogEntry
+	// sent to the Logger, or IsNotification from middleware.
+	OnCallStart func(method string)
+	OnCallEnd   func(method string, d time.Duration, err *Error)
+
+	// PanicHandler, if specified, will be called when a method panics. It
+	// receives the recovered value and the stack trace of the panic. The panic
+	// is sent to the client as an internal error.
+	//
+	// This can be used, for example, to log the panic. To re-raise the panic
+	// instead, PanicHandler may itself panic.
+	PanicHandler func(ctx context.Context, method string, v interface{}, stack []byte)
+
+	// MethodTimeout, if positive, bounds how long a method may run. The
+	// context passed to the method is cancelled once the timeout elapses. If
+	// the method has not returned by then, its result is abandoned and an
+	// error is sent to the client instead.
+	//
+	// A method that ignores its context keeps running in the background after
+	// the timeout. Under ServeConn, each request runs in its own goroutine,
+	// so an abandoned method never blocks other requests on the connection.
+	MethodTimeout time.Duration
+
+	// ReadTimeout, if positive, bounds how long ServeHTTP waits for the
+	// request body, counted from when ServeHTTP is called. If the body has
+	// not been read by then, the client is sent 408 Request Timeout and the
+	// connection is closed, so that slow clients cannot hold it open. Since
+	// NDJSON requests are read as they are served, for them it bounds the
+	// whole exchange.
+	//
+	// It is applied as a read deadline on the connection through
+	// http.ResponseController. A ResponseWriter wrapped by middleware must
+	// therefore have an Unwrap method returning the one it wraps. If the
+	// deadline cannot be set, then ReadTimeout has no effect, and only the
+	// timeouts of the http.Server bound the read.
+	ReadTimeout time.Duration
+
+	// MaxConcurrency, if positive, limits how many methods may execute at once
+	// on a single connection under ServeConn. Once the limit is reached, no
+	// more requests are read from the connection until a method returns.
+	// Responses are still sent as each method completes.
+	MaxConcurrency int
+
+	// DirectConnWrites, if true, makes ServeConn encode each message directly
+	// to the stream, instead of buffering it and writing it all at once. This
+	// saves a copy of large messages. It is only safe if the stream copes with
+	// a message arriving over several writes, or if the Encoder writes each
+	// message in a single call, as json.Encoder does.
+	DirectConnWrites bool
+
+	// MaxBatchSize, if positive, limits how many requests a batch may contain.
+	// A larger batch is rejected as a whole with StatusInvalidRequest, before
+	// any of its requests are called.
+	MaxBatchSize int
+
+	// BatchConcurrency, if positive, limits how many requests within a single
+	// batch are called at once. Otherwise every request in a batch is called
+	// concurrently. Either way, responses are sent in the order of the
+	// requests.
+	BatchConcurrency int
+
+	// BatchAbort, if specified, is called with the error of every call in a
+	// batch that fails. If it reports true, the rest of the batch is aborted:
+	// calls that have not started yet are not made, and instead fail with
+	// StatusBatchAborted, while calls in progress have their context canceled.
+	// Responses are still sent for every request.
+	//
+	// Without BatchConcurrency every call in a batch starts at once, so
+	// aborting only cancels the calls in progress.
+	BatchAbort func(e *Error) bool
+
+	// HTTPErrorStatus, if true, responds over HTTP with status 400 Bad Request
+	// when the request cannot be parsed or is invalid, instead of 200 OK. The
+	// body still holds the JSON-RPC error. Errors from methods, and errors
+	// within a batch, are still sent with 200 OK.
+	HTTPErrorStatus bool
+
+	// NDJSON, if true, accepts HTTP request bodies of Content-Type
+	// application/x-ndjson, holding any number of requests separated by
+	// newlines, as ServeConn does. The requests are called one at a time, in
+	// order, and their responses are streamed back as application/x-ndjson,
+	// flushing each one. Reading stops at the first request that cannot be
+	// parsed. These responses are not compressed.
+	NDJSON bool
+
+	// Compression, if true, enables gzip compression over HTTP. Request
+	// bodies are decompressed when the Content-Encoding is gzip, and responses
+	// are compressed when the client's Accept-Encoding includes gzip.
+	Compression bool
+
+	// Debug, if true, includes diagnostic information in errors sent to the
+	// client. Currently this is the stack trace of a method that panicked.
+	Debug bool
+
+	mu         sync.RWMutex
+	registry   map[string]*method
+	middleware []Middleware
+	get        map[string]bool
+	limits     map[string]*rate.Limiter
+	scopes     map[string][]string
+	propagate  []string
+	deprecated map[string]string
+	aliases    map[string]string
+	idempotent map[string]bool
+	pending    map[idempotencyKey]chan struct{}
+	streaming  bool // Whether any method may have its last param streamed.
+}
+
+// MethodFunc calls a registered method with the given request. The request's
+// Params are unmarshaled into the method's arguments; its Method is
+// informational only and cannot be used to call a different method.
+type MethodFunc func(ctx context.Context, req Request) (interface{}, error)
+
+// Middleware wraps a MethodFunc to add behavior around every method call, such
+// as authorization, timing or logging. A Middleware may call next with
+// modified params, return early without calling next, or alter the result or
+// error returned by next.
+type Middleware func(next MethodFunc) MethodFunc
+
+// Use adds middleware to be applied to every method call. Middleware is applied
+// in the order it was added, so the first Middleware is the outermost.
+func (h *Handler) Use(mw ...Middleware) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.middleware = append(h.middleware, mw...)
 }
 
 // NewHandler initializes a new Handler. If receivers are provided, they will
@@ -236,157 +688,1064 @@ func NewHandler(rcvrs ...interface{}) *Handler {
 //
 //     - the first parameter may be a context.Context
 //     - the remaining parameters must be able to unmarshal from JSON
-//     - return values must be (optionally) a value and (optionally) an error
-//     - if there is a return value, it must be able to marshal as JSON
+//     - return values must be (optionally) values and (optionally) an error
+//     - return values must be able to marshal as JSON; multiple values are
+//       sent as an array
 //
 // If the first parameter is a context.Context, then it will receive the context
-// from the HTTP request.
+// from the HTTP request. A context.Context in any other position is an error.
+//
+// A pointer parameter, such as *Options, receives nil for a null param, and
+// otherwise a newly allocated value for every call.
+//
+// If fn is variadic, every param after the fixed ones is unmarshaled into the
+// variadic element type. For a ...interface{} parameter the params may be of
+// mixed types, and each is unmarshaled the way encoding/json unmarshals into
+// an interface{}: numbers become float64 unless UseNumber is set.
+//
+// The last parameter may be an io.Reader or a *json.Decoder, which reads the
+// JSON text of its param instead of receiving it unmarshaled. For a single
+// POST request to ServeHTTP with positional params, the param is streamed from
+// the request body as the method reads it, so that a large upload is never
+// held in memory. This requires the request's "id" and "method" to come before
+// its "params"; members after the params are ignored. Middleware and checks
+// such as MaxParamsDepth only see the params before the streamed one, and the
+// ReadTimeout still bounds the whole body. The rest of the body is read once
+// the method returns, and if it is malformed or holds extra params, then that
+// error is sent instead of the result. The reader must not be used after the
+// method returns.
+//
+// In every other case, such as named params, a batch, ServeConn, NDJSON, or a
+// custom Decoder, Envelope or RequestInterceptor, the param's JSON text is
+// read along with the rest of the request before the method is called. If it
+// is an omitted optional param, it is nil.
+//
+// A result of type json.RawMessage is sent as it is, which lets a method pass
+// through JSON it already has, such as a cached result, without decoding it.
+// The default encoder still compacts it and escapes HTML characters unless
+// DisableHTMLEscaping is set.
+//
+// A function of type func(context.Context, json.RawMessage) (json.RawMessage,
+// error) is a raw method: it receives the params exactly as sent, or nil if
+// they were omitted or null, and its result is sent as it is. No param is
+// unmarshaled or counted, so a raw method suits proxies and methods that
+// validate their params themselves.
+//
+// RegisterMethod panics if fn is not a valid method. Use TryRegisterMethod to
+// receive an error instead.
 func (h *Handler) RegisterMethod(name string, fn interface{}) {
+	if err := h.TryRegisterMethod(name, fn); err != nil {
+		panic(err)
+	}
+}
+
+// TryRegisterMethod is like RegisterMethod but returns an error instead of
+// panicking if fn is not a valid method.
+func (h *Handler) TryRegisterMethod(name string, fn interface{}) error {
+	m, err := newMethod(name, fn)
+	if err != nil {
+		return err
+	}
+	return h.register(name, m)
+}
+
+// RegisterMethodNamed is like RegisterMethod but also names each parameter of
+// fn, not counting a leading context.Context. This allows the method to be
+// called with params as an object, where each member is mapped to the
+// parameter of the same name. Missing or unknown members are rejected.
+//
+// If fn is variadic, then the last name refers to the variadic parameter and
+// its value must be an array. It may be omitted from the params object.
+//
+// Positional params continue to work as they do for RegisterMethod.
+func (h *Handler) RegisterMethodNamed(name string, fn interface{}, argNames ...string) {
+	if err := h.TryRegisterMethodNamed(name, fn, argNames...); err != nil {
+		panic(err)
+	}
+}
+
+// TryRegisterMethodNamed is like RegisterMethodNamed but returns an error
+// instead of panicking if fn is not a valid method.
+func (h *Handler) TryRegisterMethodNamed(name string, fn interface{}, argNames ...string) error {
 	m, err := newMethod(name, fn)
 	if err != nil {
+		return err
+	}
+	nparams := m.nargs
+	if m.variadic != nil {
+		nparams++
+	}
+	if len(argNames) != nparams {
+		return fmt.Errorf("%s: %d param names given for %d params", name, len(argNames), nparams)
+	}
+	m.names = argNames
+	return h.register(name, m)
+}
+
+// MethodMeta documents a registered method for discovery through OpenRPC and
+// introspection.
+type MethodMeta struct {
+	Summary     string
+	Description string
+	Params      []string // Descriptions of the params, in order, not counting a leading context.Context.
+	Deprecated  bool
+}
+
+// RegisterMethodWithMeta is like RegisterMethod but also documents the
+// method. There must be no more param descriptions than params.
+func (h *Handler) RegisterMethodWithMeta(name string, fn interface{}, meta MethodMeta) {
+	if err := h.TryRegisterMethodWithMeta(name, fn, meta); err != nil {
+		panic(err)
+	}
+}
+
+// TryRegisterMethodWithMeta is like RegisterMethodWithMeta but returns an
+// error instead of panicking if fn is not a valid method.
+func (h *Handler) TryRegisterMethodWithMeta(name string, fn interface{}, meta MethodMeta) error {
+	m, err := newMethod(name, fn)
+	if err != nil {
+		return err
+	}
+	nparams := m.nargs
+	if m.variadic != nil {
+		nparams++
+	}
+	if len(meta.Params) > nparams {
+		return fmt.Errorf("%s: %d param descriptions given for %d params", name, len(meta.Params), nparams)
+	}
+	m.meta = meta
+	return h.register(name, m)
+}
+
+// RegisterMethodOptional is like RegisterMethod but allows trailing params of
+// fn to be omitted, as long as at least required params are given. Omitted
+// params are left as their zero value. This is unlike a variadic parameter,
+// which accepts any number of additional params of the same type.
+//
+// For example, a method func(query string, limit, offset int) registered with
+// a required count of 1 may be called with ["foo"], ["foo", 10] or
+// ["foo", 10, 20].
+//
+// The required count does not include a leading context.Context. Variadic
+// functions cannot have optional params.
+func (h *Handler) RegisterMethodOptional(name string, fn interface{}, required int) {
+	if err := h.TryRegisterMethodOptional(name, fn, required); err != nil {
+		panic(err)
+	}
+}
+
+// TryRegisterMethodOptional is like RegisterMethodOptional but returns an
+// error instead of panicking if fn is not a valid method.
+func (h *Handler) TryRegisterMethodOptional(name string, fn interface{}, required int) error {
+	m, err := newMethod(name, fn)
+	if err != nil {
+		return err
+	}
+	if m.variadic != nil {
+		return fmt.Errorf("%s: variadic methods cannot have optional params", name)
+	}
+	if required < 0 || required > m.nargs {
+		return fmt.Errorf("%s: %d required params given for %d params", name, required, m.nargs)
+	}
+	m.optional = m.nargs - required
+	return h.register(name, m)
+}
+
+// reservedPrefix begins the method names reserved by JSON-RPC 2.0.
+const reservedPrefix = "rpc."
+
+// checkName returns an error if methods cannot be registered under name. The
+// name must not be empty, begin or end with spaces, or contain control
+// characters, and it must not be reserved unless AllowReservedNames is set.
+func (h *Handler) checkName(name string) error {
+	if name == "" {
+		return errors.New("method name must not be empty")
+	}
+	if strings.TrimSpace(name) != name {
+		return fmt.Errorf("%q: method name must not begin or end with spaces", name)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%q: method name must not contain control characters", name)
+		}
+	}
+	if strings.HasPrefix(name, reservedPrefix) && !h.AllowReservedNames {
+		return fmt.Errorf("%s: method names beginning with %q are reserved", name, reservedPrefix)
+	}
+	return nil
+}
+
+func (h *Handler) register(name string, m *method) error {
+	if err := h.checkName(name); err != nil {
+		return err
+	}
+	return h.add(name, m)
+}
+
+// add registers the method under name, which has already been checked.
+func (h *Handler) add(name string, m *method) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.registry[name]; ok && h.DisallowRedefine {
+		return fmt.Errorf("%s: method already registered", name)
+	}
+	if h.registry == nil {
+		h.registry = make(map[string]*method)
+	}
+	old := h.registry[name]
+	h.registry[name] = m
+	if old != nil && old.stream {
+		h.updateStreaming()
+	} else {
+		h.streaming = h.streaming || m.stream
+	}
+	delete(h.aliases, name)
+	return nil
+}
+
+// Unregister removes the method registered under the given name. It reports
+// whether the method was registered. It is safe to call while the Handler is
+// serving requests; calls already in progress are unaffected.
+func (h *Handler) Unregister(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, ok := h.registry[name]
+	delete(h.registry, name)
+	delete(h.aliases, name)
+	if ok && m.stream {
+		h.updateStreaming()
+	}
+	return ok
+}
+
+// updateStreaming records whether any method may still have its last param
+// streamed, once one that could has been replaced or removed. h.mu must be
+// held.
+func (h *Handler) updateStreaming() {
+	h.streaming = false
+	for _, m := range h.registry {
+		if m.stream {
+			h.streaming = true
+			return
+		}
+	}
+}
+
+// Alias registers alias as another name for the method registered under
+// target, so that both names behave identically. Settings made for the target,
+// such as RequireScopes, RateLimit, Idempotent, Deprecate and AllowGET, also
+// apply to calls made through the alias, unless the same setting is made for
+// the alias itself. For example, an alias may be deprecated on its own. An
+// alias of an alias refers to the original method. Introspection and
+// OpenRPC report which method an alias refers to. Alias returns an error if
+// target is not registered or alias is already in use.
+func (h *Handler) Alias(alias, target string) error {
+	if err := h.checkName(alias); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, ok := h.registry[target]
+	if !ok {
+		return fmt.Errorf("%s: no such method to alias: %s", alias, target)
+	}
+	if _, ok := h.registry[alias]; ok {
+		return fmt.Errorf("%s: method already registered", alias)
+	}
+	if h.aliases == nil {
+		h.aliases = make(map[string]string)
+	}
+	h.registry[alias] = m
+	h.aliases[alias] = h.resolve(target)
+	return nil
+}
+
+// resolve returns the name of the method that name is an alias of, or name
+// itself. A setting not made for an alias is looked up under the resolved
+// name instead. h.mu must be held.
+func (h *Handler) resolve(name string) string {
+	if target, ok := h.aliases[name]; ok {
+		return target
+	}
+	return name
+}
+
+// HasMethod reports whether a method is registered under the given name.
+func (h *Handler) HasMethod(name string) bool {
+	return h.lookup(name) != nil
+}
+
+// AllowGET allows the named methods to also be called using HTTP GET requests,
+// which are otherwise rejected. The request is read from the query string, as
+// described by "method", "id" and "params" values. For example:
+//
+//	GET /?method=echo&id=1&params=%5B%22Hello%22%5D
+//
+// Since GET requests may be cached or repeated by clients and proxies, only
+// methods without side effects should be allowed.
+func (h *Handler) AllowGET(names ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.get == nil {
+		h.get = make(map[string]bool)
+	}
+	for _, name := range names {
+		h.get[name] = true
+	}
+}
+
+func (h *Handler) allowsGET(name string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.get[name] || h.get[h.resolve(name)]
+}
+
+// PropagateHeaders echoes the named HTTP request headers, such as
+// "X-Request-ID" or "Traceparent", on the HTTP response. This helps correlate
+// calls across services. Methods can read the same headers from the request
+// returned by HTTPRequest.
+func (h *Handler) PropagateHeaders(names ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, name := range names {
+		h.propagate = append(h.propagate, http.CanonicalHeaderKey(name))
+	}
+}
+
+// propagateHeaders copies the propagated headers from the request to the
+// response.
+func (h *Handler) propagateHeaders(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, name := range h.propagate {
+		if values, ok := r.Header[name]; ok {
+			w.Header()[name] = append([]string{}, values...)
+		}
+	}
+}
+
+// lookup returns the method registered under the given name, or nil.
+func (h *Handler) lookup(name string) *method {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.registry[name]
+}
+
+// Register is a convenience function. It will call RegisterMethod on each
+// method of the provided receiver. The registered method name will follow the
+// pattern "Type.Method".
+//
+// The receiver's method set is used, which includes methods promoted from
+// embedded fields. Methods with pointer receivers are only included if the
+// receiver is a pointer. If the receiver is a pointer to an interface, then
+// the interface's methods are used under the interface's name. It is an error
+// if the receiver has no exported methods.
+func (h *Handler) Register(rcvr interface{}) {
+	if err := h.TryRegister(rcvr); err != nil {
+		panic(err)
+	}
+}
+
+// TryRegister is like Register but returns an error instead of panicking. If
+// an error is returned, then none of the receiver's methods are registered.
+func (h *Handler) TryRegister(rcvr interface{}) error {
+	v, err := receiver(rcvr)
+	if err != nil {
+		return err
+	}
+	name := reflect.Indirect(v).Type().Name()
+	if name == "" {
+		return fmt.Errorf("cannot register unnamed type %s: use RegisterName", v.Type())
+	}
+	return h.registerName(name, v)
+}
+
+// RegisterName is like Register but uses the provided name for the type instead
+// of the receiver's concrete type.
+func (h *Handler) RegisterName(name string, rcvr interface{}) {
+	if err := h.TryRegisterName(name, rcvr); err != nil {
 		panic(err)
 	}
-	if h.registry == nil {
-		h.registry = make(map[string]*method)
+}
+
+// TryRegisterName is like RegisterName but returns an error instead of
+// panicking. If an error is returned, then none of the receiver's methods are
+// registered.
+func (h *Handler) TryRegisterName(name string, rcvr interface{}) error {
+	v, err := receiver(rcvr)
+	if err != nil {
+		return err
+	}
+	return h.registerName(name, v)
+}
+
+// receiver returns the value whose methods should be registered. A pointer to
+// an interface is dereferenced, so that the methods of the interface are used.
+func receiver(rcvr interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(rcvr)
+	if !v.IsValid() {
+		return v, errors.New("cannot register a nil receiver")
+	}
+	if v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.Interface {
+		if v.IsNil() || v.Elem().IsNil() {
+			return v, fmt.Errorf("cannot register a nil %s", v.Type().Elem())
+		}
+		v = v.Elem()
+	}
+	return v, nil
+}
+
+// methodName returns the registered name of a receiver's method.
+func (h *Handler) methodName(typeName, methodName string) string {
+	if h.NameMapper != nil {
+		return h.NameMapper(typeName, methodName)
+	}
+	return typeName + "." + methodName
+}
+
+func (h *Handler) registerName(name string, v reflect.Value) error {
+	// Validate every method before registering any of them.
+	t := v.Type()
+	methods := make(map[string]*method)
+	for i := 0; i < t.NumMethod(); i++ {
+		method := t.Method(i)
+		// Method must be exported.
+		if method.PkgPath != "" {
+			continue
+		}
+		fullName := h.methodName(name, method.Name)
+		if err := h.checkName(fullName); err != nil {
+			return err
+		}
+		m, err := newMethod(fullName, v.Method(method.Index).Interface())
+		if err != nil {
+			return err
+		}
+		methods[fullName] = m
+	}
+	if len(methods) == 0 {
+		return fmt.Errorf("%s: type %s has no exported methods", name, v.Type())
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.DisallowRedefine {
+		for fullName := range methods {
+			if _, ok := h.registry[fullName]; ok {
+				return fmt.Errorf("%s: method already registered", fullName)
+			}
+		}
+	}
+	if h.registry == nil {
+		h.registry = make(map[string]*method)
+	}
+	for fullName, m := range methods {
+		h.registry[fullName] = m
+	}
+	h.updateStreaming()
+	return nil
+}
+
+// ServeConn provides JSON-RPC over any bi-directional stream. It is the same
+// as calling Serve on the Conn returned by NewConn.
+//
+// Methods may send notifications to the client over the same stream using the
+// Conn from ConnFromContext.
+//
+// ServeConn does not close rw; the caller owns the stream. Use
+// ServeConnAndClose to hand it over instead. The exception is when ServeConn
+// must stop while it is blocked reading, because ctx is done or a write
+// failed. A read deadline is then used to interrupt the read if rw has a
+// SetReadDeadline method, and otherwise rw is closed if it is an io.Closer.
+func (h *Handler) ServeConn(ctx context.Context, rw io.ReadWriter) {
+	h.NewConn(rw).Serve(ctx)
+}
+
+// ServeConnAndClose is like ServeConn, but takes ownership of the stream and
+// closes it once ServeConn returns, including when a read error ends the
+// connection early.
+func (h *Handler) ServeConnAndClose(ctx context.Context, rwc io.ReadWriteCloser) {
+	defer rwc.Close()
+	h.ServeConn(ctx, rwc)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.propagateHeaders(w, r)
+
+	// Deal with HTTP-level errors.
+	ndjson := h.NDJSON && isNDJSONMediaType(r.Header.Get("Content-Type"))
+	if ct, ok := r.Header["Content-Type"]; ok && len(ct) > 0 && !isJSONMediaType(ct[0]) && !ndjson {
+		http.Error(w, "Unsupported Content-Type: must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	get := r.Method == "GET" && h.allowsGET(r.URL.Query().Get("method"))
+	if r.Method != "POST" && !get {
+		http.Error(w, "Unsupported method: must be POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// All other requests return status OK. Errors are returned as JSON-RPC.
+
+	ctx := r.Context()
+	hr := *r
+	hr.Body = http.NoBody
+	ctx = context.WithValue(ctx, httpRequestKey, &hr)
+	rh := new(responseHeader)
+	ctx = context.WithValue(ctx, responseHeaderKey, rh)
+	if d, err := time.ParseDuration(r.Header.Get(TimeoutHeader)); err == nil && d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	req := getRequest()
+	defer putRequest(req)
+	var stream *paramStream
+	if get {
+		h.decodeQuery(ctx, r.URL.Query(), req)
+	} else {
+		var rd io.Reader = r.Body
+		if h.ReadTimeout > 0 {
+			// Without support for deadlines, the body is read without one.
+			// Abandoning a blocked read instead would leave it holding the
+			// connection after ServeHTTP returns.
+			_ = http.NewResponseController(w).SetReadDeadline(time.Now().Add(h.ReadTimeout))
+		}
+		if h.Compression && r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(rd)
+			if h.readTimedOut(err) {
+				writeReadTimeout(w)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Invalid gzip body", http.StatusBadRequest)
+				return
+			}
+			defer func() {
+				if stream == nil || !stream.abandoned {
+					gz.Close()
+				}
+			}()
+			rd = gz
+		}
+		body := readerPool.Get().(*bufio.Reader)
+		body.Reset(rd)
+		defer func() {
+			// A method still reading its streamed param keeps the body.
+			if stream == nil || !stream.abandoned {
+				body.Reset(nil)
+				readerPool.Put(body)
+			}
+		}()
+		skipBOM(body)
+		dec := h.newDecoder(body)
+
+		if ndjson {
+			h.serveNDJSON(ctx, w, r, dec)
+			return
+		}
+		if isBatch(body) {
+			h.serveBatch(ctx, w, r, dec)
+			return
+		}
+
+		if h.streams() {
+			var replay io.Reader
+			if stream, replay = h.decodeStream(ctx, body, req); stream == nil {
+				dec = h.newDecoder(replay)
+			}
+		}
+		if stream != nil {
+			ctx = context.WithValue(ctx, streamKey, stream)
+		} else if !h.decodeRequest(ctx, dec, req) && req.res.Error == nil {
+			req.res.ID = jsonrpcID("null")
+			req.res.Error = WrapError(io.EOF)
+			req.res.Error.Code = StatusInvalidRequest
+		}
+		if h.readTimedOut(req.res.Error) {
+			writeReadTimeout(w)
+			return
+		}
+	}
+	if key := r.Header.Get(IdempotencyHeader); key != "" && req.IdempotencyKey == "" {
+		req.IdempotencyKey = key
+	}
+	req.raw = true
+	h.serve(ctx, req)
+	if stream != nil {
+		// Read the rest of the request once the method has streamed its param.
+		err := stream.finish(req.res.Error == nil)
+		if h.readTimedOut(err) {
+			writeReadTimeout(w)
+			return
+		}
+		if err != nil && req.res.Error == nil {
+			e, ok := err.(*Error)
+			if !ok {
+				e = WrapError(err)
+				e.Code = StatusInvalidRequest
+			}
+			req.res.Result, req.res.Error = nil, e
+		}
+	}
+	warnDeprecated(w, req)
+	setRetryAfter(w, req)
+	rh.writeTo(w)
+
+	// A notification receives no response, even if it failed. Only a request
+	// that could not be read at all is answered with a null ID.
+	var err error
+	raw, isRaw := req.res.Result.(Raw)
+	if req.res.ID == nil {
+		w.WriteHeader(http.StatusNoContent)
+	} else if isRaw && req.res.Error == nil {
+		if raw != nil {
+			err = raw(w)
+		}
+	} else {
+		err = h.writeJSON(w, r, h.httpStatus(req.res.Error), h.message(ctx, req))
+	}
+	h.log(req, err)
+}
+
+// httpStatus returns the HTTP status code of a response with the given error.
+func (h *Handler) httpStatus(e *Error) int {
+	if h.HTTPErrorStatus && e != nil {
+		switch e.Code {
+		case StatusParseError, StatusInvalidRequest:
+			return http.StatusBadRequest
+		}
+	}
+	return http.StatusOK
+}
+
+// writeJSON sends v as the response body with the given status code. If
+// Compression is enabled and the client accepts it, then the body is
+// compressed using gzip.
+func (h *Handler) writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) error {
+	// Nobody will read the response once the client has gone away.
+	if err := r.Context().Err(); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	var out io.Writer = w
+	if h.Compression {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			out = gz
+		}
+	}
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+	return h.newEncoder(out).Encode(v)
+}
+
+// acceptsGzip reports whether the client accepts a gzip-encoded response.
+func acceptsGzip(r *http.Request) bool {
+	for _, header := range r.Header["Accept-Encoding"] {
+		for _, coding := range strings.Split(header, ",") {
+			parts := strings.Split(coding, ";")
+			if strings.TrimSpace(parts[0]) != "gzip" {
+				continue
+			}
+			// A quality of zero means the coding is not acceptable.
+			for _, param := range parts[1:] {
+				if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+					if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v == 0 {
+						return false
+					}
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// isJSONMediaType reports whether the Content-Type is application/json or
+// application/json-rpc. Parameters such as charset are ignored.
+func isJSONMediaType(ct string) bool {
+	mediatype, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return mediatype == "application/json" || mediatype == "application/json-rpc"
+}
+
+// isNDJSONMediaType reports whether the Content-Type is application/x-ndjson.
+func isNDJSONMediaType(ct string) bool {
+	mediatype, _, err := mime.ParseMediaType(ct)
+	return err == nil && mediatype == "application/x-ndjson"
+}
+
+// serveNDJSON handles a body of newline-delimited requests. Each request is
+// called in turn, and its response is written and flushed before the next
+// request is read.
+func (h *Handler) serveNDJSON(ctx context.Context, w http.ResponseWriter, r *http.Request, dec Decoder) {
+	var enc Encoder
+	for {
+		req := getRequest()
+		more := h.decodeRequest(ctx, dec, req)
+		if !more && req.res.Error == nil {
+			putRequest(req)
+			break
+		}
+		if h.readTimedOut(req.res.Error) {
+			putRequest(req)
+			if enc == nil {
+				writeReadTimeout(w)
+				return
+			}
+			break
+		}
+		h.serve(ctx, req)
+
+		var err error
+		if req.res.ID != nil {
+			if enc == nil {
+				warnDeprecated(w, req)
+				setRetryAfter(w, req)
+				writeResponseHeader(ctx, w)
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				enc = h.newEncoder(w)
+			}
+			if err = enc.Encode(h.message(ctx, req)); err == nil {
+				if f, ok := w.(http.Flusher); ok {
+					f.Flush()
+				}
+			}
+		}
+		h.log(req, err)
+		putRequest(req)
+		if !more || err != nil || r.Context().Err() != nil {
+			break
+		}
+	}
+	if enc == nil {
+		writeResponseHeader(ctx, w)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// serveBatch handles a JSON-RPC batch, which is an array of requests. Each
+// request is called concurrently and the responses are sent back as an array.
+func (h *Handler) serveBatch(ctx context.Context, w http.ResponseWriter, r *http.Request, dec Decoder) {
+	reqs, e := h.decodeBatch(ctx, dec)
+	if h.readTimedOut(e) {
+		writeReadTimeout(w)
+		return
+	}
+	if e != nil {
+		req := &request{res: response{errorResponse: errorResponse{Protocol: "2.0", ID: jsonrpcID("null"), Error: e}}}
+		h.log(req, h.writeJSON(w, r, h.httpStatus(e), h.message(ctx, req)))
+		return
+	}
+
+	// Limit the number of methods executing at once.
+	var sem chan struct{}
+	if h.BatchConcurrency > 0 {
+		sem = make(chan struct{}, h.BatchConcurrency)
+	}
+
+	// The batch is aborted by canceling the context shared by its calls.
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+
+	var wg sync.WaitGroup
+	for _, req := range reqs {
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		wg.Add(1)
+		go func(req *request) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			aborted := req.res.Error == nil && ctx.Err() != nil
+			if aborted {
+				req.res.Error = &Error{
+					Code:    StatusBatchAborted,
+					Message: "Batch aborted",
+				}
+			}
+			h.serve(ctx, req)
+			if !aborted && req.res.Error != nil && h.BatchAbort != nil && h.BatchAbort(req.res.Error) {
+				abort()
+			}
+		}(req)
+	}
+	wg.Wait()
+	warnDeprecated(w, reqs...)
+	setRetryAfter(w, reqs...)
+	writeResponseHeader(ctx, w)
+
+	// Responses are sent in request order. Notifications do not get a
+	// response.
+	var msgs []interface{}
+	for _, req := range reqs {
+		if req.res.ID != nil {
+			msgs = append(msgs, h.message(ctx, req))
+		}
+	}
+	var err error
+	if len(msgs) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		err = h.writeJSON(w, r, http.StatusOK, msgs)
+	}
+	for _, req := range reqs {
+		if req.res.ID != nil {
+			h.log(req, err)
+		} else {
+			h.log(req, nil)
+		}
+	}
+}
+
+// decodeBatch decodes every request in a batch. If the batch itself is
+// malformed or empty, then an Error is returned and no requests should be
+// called. Errors in individual requests are reported on each request.
+func (h *Handler) decodeBatch(ctx context.Context, dec Decoder) ([]*request, *Error) {
+	var raw []json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, &Error{Code: StatusParseError, Message: err.Error(), original: err}
+	}
+	if len(raw) == 0 {
+		return nil, &Error{Code: StatusInvalidRequest, Message: "Invalid request: empty batch"}
+	}
+	if h.MaxBatchSize > 0 && len(raw) > h.MaxBatchSize {
+		return nil, &Error{
+			Code:    StatusInvalidRequest,
+			Message: fmt.Sprintf("Invalid request: batch exceeds %d requests", h.MaxBatchSize),
+		}
+	}
+
+	reqs := make([]*request, len(raw))
+	for i := range raw {
+		reqs[i] = new(request)
+		h.decodeRequest(ctx, h.newDecoder(bytes.NewReader(raw[i])), reqs[i])
 	}
-	h.registry[name] = m
+	return reqs, nil
 }
 
-// Register is a convenience function. It will call RegisterMethod on each
-// method of the provided receiver. The registered method name will follow the
-// pattern "Type.Method".
-func (h *Handler) Register(rcvr interface{}) {
-	v := reflect.ValueOf(rcvr)
-	name := reflect.Indirect(v).Type().Name()
-	h.registerName(name, v)
+// readTimedOut reports whether err came from reading a request body past the
+// ReadTimeout.
+func (h *Handler) readTimedOut(err error) bool {
+	return h.ReadTimeout > 0 && errors.Is(err, os.ErrDeadlineExceeded)
 }
 
-// RegisterName is like Register but uses the provided name for the type instead
-// of the receiver's concrete type.
-func (h *Handler) RegisterName(name string, rcvr interface{}) {
-	h.registerName(name, reflect.ValueOf(rcvr))
+// writeReadTimeout answers a request whose body was not read within the
+// ReadTimeout, and closes the connection.
+func writeReadTimeout(w http.ResponseWriter) {
+	w.Header().Set("Connection", "close")
+	http.Error(w, "Timed out reading request body", http.StatusRequestTimeout)
 }
 
-func (h *Handler) registerName(name string, v reflect.Value) {
-	t := v.Type()
-	for i := 0; i < t.NumMethod(); i++ {
-		method := t.Method(i)
-		// Method must be exported.
-		if method.PkgPath != "" {
-			continue
-		}
-		h.RegisterMethod(name+"."+method.Name, v.Method(method.Index).Interface())
+// skipBOM discards a UTF-8 byte order mark at the start of r, which some
+// clients send even though JSON must not begin with one. It never reads past
+// the first byte unless that byte could begin a byte order mark, so it does
+// not block waiting for a short message to be followed by more.
+func skipBOM(r *bufio.Reader) {
+	if b, err := r.Peek(1); err != nil || b[0] != 0xEF {
+		return
+	}
+	if b, err := r.Peek(3); err == nil && string(b) == "\xEF\xBB\xBF" {
+		r.Discard(3)
 	}
 }
 
-// ServeConn provides JSON-RPC over any bi-directional stream.
-func (h *Handler) ServeConn(ctx context.Context, rw io.ReadWriter) {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	var l sync.Mutex
-	var buf bytes.Buffer
-
-	var wg sync.WaitGroup
-	dec := json.NewDecoder(rw)
-	enc := h.newEncoder(&buf)
-	send := func(res *response) {
-		// Write the entire buffer as a single write, to help e.g. a
-		// websocket adapter send it as one frame.
-		l.Lock()
-		defer l.Unlock()
-
-		var err error
-		if res.Error != nil {
-			err = enc.Encode(res.errorResponse)
-		} else {
-			err = enc.Encode(res)
+// isBatch reports whether the next non-whitespace byte begins a JSON array.
+// Leading whitespace is consumed.
+func isBatch(r *bufio.Reader) bool {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return false
 		}
-		if err == nil {
-			_, err = buf.WriteTo(rw)
-			buf.Reset()
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
 		}
+		r.UnreadByte()
+		return b == '['
+	}
+}
 
-		// If write fails, the writer is no longer valid.
-		if err != nil {
-			cancel()
-		}
+// call calls the method for the request and records the result or error on
+// the response.
+func (h *Handler) call(ctx context.Context, req *request) {
+	req.res.Protocol = "2.0"
+	req.res.ID = req.ID
+	if h.ContextFunc != nil {
+		ctx = h.ContextFunc(ctx)
 	}
 
-	for {
-		req := new(request)
-		if !h.decodeRequest(ctx, dec, req) {
-			if req.res.Error != nil {
-				// Errors will only occur for parse errors, in which case we
-				// cannot tell if the request was a notification and the client
-				// is not expecting a response. Send the error just to be safe.
-				send(&req.res)
-			}
-			// No more values are available.
-			wg.Wait()
+	// Call the method through the middleware chain.
+	fn := h.chain(req.m)
+	header := Request{Method: req.Method, Params: req.Params}
+	var result interface{}
+	var err error
+	if h.MethodTimeout > 0 {
+		result, err = callWithTimeout(ctx, h.MethodTimeout, fn, header)
+	} else {
+		result, err = fn(ctx, header)
+	}
+	if err == nil && h.ResultTransformer != nil {
+		result, err = h.ResultTransformer(ctx, req.Method, result)
+	}
+	if err == nil {
+		result, err = checkRaw(req, result)
+	}
+	if err != nil {
+		// Check for recovered panics.
+		if p, ok := err.(*panicError); ok {
+			req.res.Error = h.recovered(ctx, req, p)
 			return
 		}
+		// Check for pre-existing JSON-RPC errors.
+		if e, ok := err.(*Error); ok && e != nil {
+			req.res.Error = e
+			return
+		}
+		// Create a generic JSON-RPC error.
+		req.res.Error = h.mapError(err)
+		return
+	}
+	req.res.Result = result
+}
 
-		// Start the call in its own goroutine.
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-
-			if req.res.Error == nil {
-				// Call the method.
-				req.call(ctx)
-			}
+// callWithTimeout calls fn with a context that expires after the timeout. If fn
+// has not returned by then, its result is abandoned and the context's error is
+// returned instead.
+func callWithTimeout(ctx context.Context, timeout time.Duration, fn MethodFunc, req Request) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-			h.interceptResponse(ctx, req)
+	type outcome struct {
+		result interface{}
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := fn(ctx, req)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
 
-			if req.res.ID == nil {
-				return
+// chain wraps the method in all middleware registered with Use.
+// If m is nil, then the Fallback is called instead.
+func (h *Handler) chain(m *method) MethodFunc {
+	next := MethodFunc(func(ctx context.Context, req Request) (interface{}, error) {
+		if e := h.checkParams(req.Method, req.Params); e != nil {
+			return nil, e
+		}
+		if m == nil {
+			result, err := h.Fallback(ctx, req.Method, req.Params)
+			if err == ErrMethodNotFound {
+				return nil, h.methodNotFound(req.Method)
 			}
-
-			send(&req.res)
-		}()
+			return result, err
+		}
+		return m.call(ctx, req.Params, h.unmarshalParam)
+	})
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		next = h.middleware[i](next)
 	}
+	return next
 }
 
-func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Deal with HTTP-level errors.
-	if ct, ok := r.Header["Content-Type"]; ok && len(ct) > 0 && ct[0] != "application/json" {
-		http.Error(w, "Unsupported Content-Type: must be application/json", http.StatusUnsupportedMediaType)
-		return
+// mapError converts an error that is not already a JSON-RPC error using the
+// ErrorMapper. Otherwise the cancellation of a context is reported with its own
+// code, and any other error is wrapped as an internal error.
+func (h *Handler) mapError(err error) *Error {
+	if h.ErrorMapper != nil {
+		if e := h.ErrorMapper(err); e != nil {
+			if e.original == nil {
+				// Keep the cause without modifying the mapper's Error.
+				c := *e
+				c.original = err
+				e = &c
+			}
+			return e
+		}
 	}
-	if r.Method != "POST" {
-		http.Error(w, "Unsupported method: must be POST", http.StatusMethodNotAllowed)
-		return
+	switch {
+	case errors.Is(err, context.Canceled):
+		return &Error{Code: StatusCancelled, Message: "Request cancelled", original: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Code: StatusDeadlineExceeded, Message: "Deadline exceeded", original: err}
+	}
+	if h.MaskInternalErrors {
+		return maskError(err)
 	}
+	return WrapError(err)
+}
 
-	// All other requests return status OK. Errors are returned as JSON-RPC.
+// maskError wraps an error as an internal error without revealing its message.
+func maskError(err error) *Error {
+	return &Error{
+		Code:     StatusInternalError,
+		Message:  "Internal error",
+		original: err,
+	}
+}
 
-	ctx := r.Context()
-	dec := json.NewDecoder(r.Body)
-	enc := h.newEncoder(w)
+// recovered reports a panic from a method to the PanicHandler and converts it
+// into a JSON-RPC error.
+func (h *Handler) recovered(ctx context.Context, req *request, p *panicError) *Error {
+	if h.PanicHandler != nil {
+		h.PanicHandler(ctx, req.Method, p.value, p.stack)
+	}
+	var e *Error
+	if h.MaskInternalErrors {
+		e = maskError(p)
+	} else {
+		e = WrapError(p)
+	}
+	if h.Debug {
+		e.Data = string(p.stack)
+	}
+	return e
+}
 
-	var req request
-	if !h.decodeRequest(ctx, dec, &req) && req.res.Error == nil {
-		req.res.ID = jsonrpcID("null")
-		req.res.Error = WrapError(io.EOF)
-		req.res.Error.Code = StatusInvalidRequest
+// serve calls the method for a decoded request, unless decoding already
+// produced an error, and then applies the ResponseInterceptor. The metrics
+// callbacks are invoked around it.
+func (h *Handler) serve(ctx context.Context, req *request) {
+	ctx = withCall(ctx, req)
+	if h.OnCallStart != nil {
+		h.OnCallStart(req.Method)
 	}
+
 	if req.res.Error == nil {
 		// Call the method.
-		req.call(ctx)
+		start := time.Now()
+		h.callOnce(ctx, req)
+		req.duration = time.Since(start)
 	}
 
-	h.interceptResponse(ctx, &req)
+	h.interceptResponse(ctx, req)
 
-	if req.res.ID == nil {
-		w.WriteHeader(http.StatusNoContent)
-	} else {
-		w.Header().Set("Content-Type", "application/json")
-		if req.res.Error != nil {
-			enc.Encode(req.res.errorResponse)
-		} else {
-			enc.Encode(req.res)
-		}
+	if h.OnCallEnd != nil {
+		h.OnCallEnd(req.Method, req.duration, req.res.Error)
 	}
 }
 
@@ -424,15 +1783,30 @@ func (h *Handler) interceptResponse(ctx context.Context, req *request) {
 // Decode a value into the request. If there was an error, the errorResponse
 // will be non-nil. Returns false if there are no more values available from
 // the decoder.
-func (h *Handler) decodeRequest(ctx context.Context, dec *json.Decoder, req *request) bool {
+func (h *Handler) decodeRequest(ctx context.Context, dec Decoder, req *request) bool {
 	req.res.Protocol = "2.0"
 
 	// Unmarshal the request. We do all the usual checks per the protocol.
-	if err := dec.Decode(req); err != nil {
+	var err error
+	if h.Envelope != nil {
+		err = h.decodeEnvelope(dec, req)
+	} else {
+		err = dec.Decode(req)
+	}
+	if err != nil {
 		if err == io.EOF {
 			return false
 		}
 		req.res.ID = jsonrpcID("null")
+		var idErr *invalidIDError
+		if errors.As(err, &idErr) {
+			// The request was read, so the stream may continue.
+			req.res.Error = &Error{
+				Code:    StatusInvalidRequest,
+				Message: err.Error(),
+			}
+			return true
+		}
 		if _, ok := err.(*json.SyntaxError); ok {
 			req.res.Error = WrapError(err)
 			req.res.Error.Code = StatusParseError
@@ -444,43 +1818,161 @@ func (h *Handler) decodeRequest(ctx context.Context, dec *json.Decoder, req *req
 		return false
 	}
 
+	h.prepareRequest(ctx, req)
+	return true
+}
+
+// decodeQuery decodes a request from the query string of a GET request. The
+// "method" is a string, while the "id" and "params" are JSON values. If "id" is
+// omitted, then the request is a notification.
+func (h *Handler) decodeQuery(ctx context.Context, q url.Values, req *request) {
+	req.res.Protocol = "2.0"
+	req.Protocol = "2.0"
+	req.Method = q.Get("method")
+
+	if id, ok := q["id"]; ok {
+		if err := req.ID.UnmarshalJSON([]byte(id[0])); err != nil {
+			req.res.ID = jsonrpcID("null")
+			req.res.Error = WrapError(err)
+			req.res.Error.Code = StatusInvalidRequest
+			return
+		}
+	}
+	if params := q.Get("params"); params != "" {
+		if !json.Valid([]byte(params)) {
+			req.res.ID = jsonrpcID("null")
+			req.res.Error = &Error{
+				Code:    StatusParseError,
+				Message: "Invalid params: must be a JSON value",
+			}
+			return
+		}
+		req.Params = json.RawMessage(params)
+	}
+
+	h.prepareRequest(ctx, req)
+}
+
+// prepareRequest validates a decoded request and looks up its method. If there
+// was an error, the errorResponse will be non-nil.
+func (h *Handler) prepareRequest(ctx context.Context, req *request) {
+	if req.Protocol == "" && h.AllowV1 {
+		// JSON-RPC 1.0 requests are notifications when their ID is null.
+		if bytes.Equal(req.ID, jsonrpcID("null")) {
+			req.ID = nil
+		}
+		req.res.v1 = true
+	}
 	req.res.ID = req.ID
-	if req.Protocol != "2.0" {
+	if req.Protocol != "2.0" && !req.res.v1 {
 		req.res.Error = &Error{
 			Code:    StatusInvalidRequest,
 			Message: "Invalid protocol: expected jsonrpc: 2.0",
 		}
-		return true
+		return
 	}
 
 	h.interceptRequest(ctx, req)
 	if req.res.Error != nil {
-		return true
+		return
 	}
 
-	if h.registry != nil {
-		req.m = h.registry[req.Method]
+	req.m = h.lookup(req.Method)
+	if req.m == nil && h.Fallback == nil {
+		req.res.Error = h.methodNotFound(req.Method)
+		return
 	}
 
-	if req.m == nil {
-		req.res.Error = &Error{
-			Code:    StatusMethodNotFound,
-			Message: fmt.Sprintf("No such method: %s", req.Method),
-		}
+	req.res.Error = h.authorize(ctx, req.Method)
+	if req.res.Error != nil {
+		return
+	}
+
+	req.res.Error = h.checkRateLimit(req.Method)
+	if req.res.Error != nil {
+		return
+	}
+
+	req.deprecation = h.deprecation(req.Method)
+}
+
+func (h *Handler) newDecoder(r io.Reader) Decoder {
+	if h.Decoder == nil {
+		return json.NewDecoder(r)
+	}
+	return h.Decoder(r)
+}
+
+// unmarshalParam unmarshals a single param into an argument.
+func (h *Handler) unmarshalParam(data []byte, v interface{}) error {
+	err := h.decodeParam(data, v)
+	if err == nil || !h.CoerceStringNumbers || !isNumberOrBool(reflect.TypeOf(v).Elem()) {
+		return err
+	}
+	// Retry with the contents of a quoted string, like the ",string" option
+	// of encoding/json.
+	var str string
+	if json.Unmarshal(data, &str) != nil {
+		return err
+	}
+	if h.decodeParam([]byte(str), v) != nil {
+		return err
+	}
+	return nil
+}
+
+// isNumberOrBool reports whether t, or what it points to, is a number or
+// boolean.
+func isNumberOrBool(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
 		return true
 	}
+	return false
+}
 
-	return true
+// decodeParam decodes a single param using the Handler's Decoder.
+func (h *Handler) decodeParam(data []byte, v interface{}) error {
+	if h.Decoder == nil && !h.DisallowUnknownParams && !h.UseNumber {
+		return json.Unmarshal(data, v)
+	}
+	dec := h.newDecoder(bytes.NewReader(data))
+	if h.DisallowUnknownParams {
+		if d, ok := dec.(interface{ DisallowUnknownFields() }); ok {
+			d.DisallowUnknownFields()
+		}
+	}
+	if h.UseNumber {
+		if d, ok := dec.(interface{ UseNumber() }); ok {
+			d.UseNumber()
+		}
+	}
+	return dec.Decode(v)
 }
 
 func (h *Handler) newEncoder(w io.Writer) Encoder {
 	if h.Encoder == nil {
-		return json.NewEncoder(w)
+		enc := json.NewEncoder(w)
+		if h.IndentPrefix != "" || h.Indent != "" {
+			enc.SetIndent(h.IndentPrefix, h.Indent)
+		}
+		if h.DisableHTMLEscaping {
+			enc.SetEscapeHTML(false)
+		}
+		return enc
 	}
 	return h.Encoder(w)
 }
 
 var (
+	readerType  = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	decoderType = reflect.TypeOf((*json.Decoder)(nil))
 	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
 	errorType   = reflect.TypeOf((*error)(nil)).Elem()
 	zeroValue   = reflect.Value{}
@@ -494,9 +1986,21 @@ type method struct {
 	nargs      int
 	ins        []reflect.Type
 	variadic   reflect.Type
+	optional   int // Number of trailing arguments that may be omitted.
+	names      []string
+	meta       MethodMeta
 
 	hasError    bool
 	hasResponse bool
+	nresults    int
+
+	// raw is set for a raw method, which is called with its params as they
+	// are instead of through reflection.
+	raw func(context.Context, json.RawMessage) (json.RawMessage, error)
+
+	// stream is set if the last argument reads its param as JSON text, which
+	// may then be streamed from the request body.
+	stream bool
 }
 
 func newMethod(name string, fn interface{}) (*method, error) {
@@ -505,6 +2009,9 @@ func newMethod(name string, fn interface{}) (*method, error) {
 		return nil, fmt.Errorf("%s: cannot use type as a method: %T", name, fn)
 	}
 	t := m.Type()
+	if raw, ok := fn.(func(context.Context, json.RawMessage) (json.RawMessage, error)); ok {
+		m.raw = raw
+	}
 
 	// Prepare "In" types.
 	m.nargs = t.NumIn()
@@ -521,6 +2028,14 @@ func newMethod(name string, fn interface{}) (*method, error) {
 		m.nargs--
 	}
 
+	// A context.Context is only supported as the first argument. Anywhere else
+	// it could never be unmarshaled from JSON.
+	for _, in := range m.ins {
+		if in == contextType || (t.IsVariadic() && in == reflect.SliceOf(contextType)) {
+			return nil, fmt.Errorf("%s: context.Context must be the first parameter: %T", name, fn)
+		}
+	}
+
 	// If the function is variadic, then the last argument is actually a slice
 	// type. We want the type of the slice element.
 	if t.IsVariadic() {
@@ -529,6 +2044,27 @@ func newMethod(name string, fn interface{}) (*method, error) {
 		m.nargs--
 	}
 
+	// Only the last argument may read its param as raw JSON.
+	for i, in := range m.ins {
+		if (in == readerType || in == decoderType) && (i != len(m.ins)-1 || m.variadic != nil) {
+			return nil, fmt.Errorf("%s: %s must be the last parameter", name, in)
+		}
+	}
+	if m.nargs > 0 && m.variadic == nil {
+		last := m.ins[m.nargs-1]
+		m.stream = last == readerType || last == decoderType
+	}
+
+	// Every argument must be able to unmarshal from JSON.
+	for _, in := range m.ins {
+		if !canUnmarshal(in) {
+			return nil, fmt.Errorf("%s: cannot unmarshal JSON into parameter of type %s", name, in)
+		}
+	}
+	if m.variadic != nil && !canUnmarshal(m.variadic) {
+		return nil, fmt.Errorf("%s: cannot unmarshal JSON into parameter of type %s", name, m.variadic)
+	}
+
 	// Check if the function returns an error.
 	i := t.NumOut() - 1
 	if i >= 0 && t.Out(i).Implements(errorType) {
@@ -536,28 +2072,86 @@ func newMethod(name string, fn interface{}) (*method, error) {
 		i--
 	}
 
-	// Check if the function returns a result.
-	if i >= 0 {
-		m.hasResponse = true
-		i--
-	}
-
-	// Check if there are more return arguments. If so, this is illegal.
-	if i >= 0 {
-		return nil, fmt.Errorf("%s: too many output arguments for method: %T", name, fn)
-	}
+	// Check if the function returns results. Multiple results are sent as an
+	// array.
+	m.nresults = i + 1
+	m.hasResponse = m.nresults > 0
 
 	return m, nil
 }
 
-func (m *method) call(ctx context.Context, params json.RawMessage) (result interface{}, err error) {
+// canUnmarshal reports whether JSON can ever be unmarshaled into the type. Some
+// kinds, such as channels and functions, are never supported unless the type
+// unmarshals itself. Struct fields are not checked, since they are only
+// unmarshaled when present.
+func canUnmarshal(t reflect.Type) bool {
+	if reflect.PtrTo(t).Implements(jsonUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return false
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return canUnmarshal(t.Elem())
+	case reflect.Map:
+		return canUnmarshal(t.Key()) && canUnmarshal(t.Elem())
+	}
+	return true
+}
+
+// call unmarshals the params into the method's arguments using unmarshal, and
+// then calls the method.
+func (m *method) call(ctx context.Context, params json.RawMessage, unmarshal func(data []byte, v interface{}) error) (result interface{}, err error) {
+	if m.raw != nil {
+		return m.callRaw(ctx, params)
+	}
+
 	// Prepare raw arguments.
 	var args []json.RawMessage
-	if len(params) > 0 && string(params) != "null" {
-		// Params may be an array or an object.
+	var stream io.Reader
+	switch paramsKind(params) {
+	case 0, 'n':
+		// No params.
+	case '{':
+		if m.nargs == 0 && m.variadic == nil && isEmptyObject(params) {
+			// A method without params accepts an empty object as it does an
+			// empty array.
+			break
+		}
+		if m.names != nil {
+			// Named params are mapped to positional arguments by name.
+			args, err = m.namedArgs(params)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		// Otherwise named params are unmarshaled by name into a single
+		// argument, such as a struct or map.
+		args = []json.RawMessage{params}
+	case '[':
+		// Positional params are unmarshaled by position.
 		if err := json.Unmarshal(params, &args); err != nil {
-			args = []json.RawMessage{params}
+			return nil, &Error{
+				Code:    StatusInvalidParams,
+				Message: fmt.Sprintf("%s: %s", m.name, err),
+			}
+		}
+		// The last param may instead be streamed from the request body.
+		if s, ok := ctx.Value(streamKey).(*paramStream); ok && s.take(m) {
+			stream = s
+			args = append(args, nil)
+		}
+	default:
+		// The spec requires params to be structured. As a convenience, a
+		// method taking exactly one param also accepts it unwrapped.
+		if m.nargs != 1 || m.variadic != nil {
+			return nil, &Error{
+				Code:    StatusInvalidParams,
+				Message: fmt.Sprintf("%s: params must be array, object, or omitted", m.name),
+			}
 		}
+		args = []json.RawMessage{params}
 	}
 
 	// Verify the correct number of arguments.
@@ -568,6 +2162,13 @@ func (m *method) call(ctx context.Context, params json.RawMessage) (result inter
 				Message: fmt.Sprintf("%s: require at least %d params", m.name, m.nargs),
 			}
 		}
+	} else if m.optional > 0 {
+		if len(args) < m.nargs-m.optional || len(args) > m.nargs {
+			return nil, &Error{
+				Code:    StatusInvalidParams,
+				Message: fmt.Sprintf("%s: require %d to %d params", m.name, m.nargs-m.optional, m.nargs),
+			}
+		}
 	} else if len(args) != m.nargs {
 		return nil, &Error{
 			Code:    StatusInvalidParams,
@@ -575,14 +2176,19 @@ func (m *method) call(ctx context.Context, params json.RawMessage) (result inter
 		}
 	}
 
-	// Unmarshal the params.
+	// Unmarshal the params. Omitted optional arguments are left as zero
+	// values.
+	n := len(args)
+	if n < m.nargs {
+		n = m.nargs
+	}
 	var ins, provided []reflect.Value
 	if m.hasContext {
-		ins = make([]reflect.Value, len(args)+1)
+		ins = make([]reflect.Value, n+1)
 		ins[0] = reflect.ValueOf(ctx)
 		provided = ins[1:]
 	} else {
-		ins = make([]reflect.Value, len(args))
+		ins = make([]reflect.Value, n)
 		provided = ins
 	}
 	for i := range provided {
@@ -592,18 +2198,37 @@ func (m *method) call(ctx context.Context, params json.RawMessage) (result inter
 		} else {
 			t = m.variadic
 		}
+		if i >= len(args) {
+			provided[i] = reflect.Zero(t)
+			continue
+		}
+		if t == readerType || t == decoderType {
+			var r io.Reader = bytes.NewReader(args[i])
+			if stream != nil && i == len(args)-1 {
+				r = stream
+			}
+			if t == readerType {
+				provided[i] = reflect.ValueOf(&r).Elem()
+			} else {
+				provided[i] = reflect.ValueOf(json.NewDecoder(r))
+			}
+			continue
+		}
 		v := reflect.New(t)
-		if err := json.Unmarshal(args[i], v.Interface()); err != nil {
+		if err := unmarshal(args[i], v.Interface()); err != nil {
 			e := WrapError(fmt.Errorf("%s: %w", m.name, err))
 			e.Code = StatusInvalidParams
-			e.Data = args[i]
+			e.Data = m.invalidParam(i, args[i])
 			return nil, e
 		}
 		provided[i] = v.Elem()
 	}
 
 	// Call the function.
-	outs := m.Call(ins)
+	outs, err := m.safeCall(ins)
+	if err != nil {
+		return nil, err
+	}
 
 	// Report error (if any).
 	if m.hasError {
@@ -614,10 +2239,124 @@ func (m *method) call(ctx context.Context, params json.RawMessage) (result inter
 	}
 
 	// Report response (if any).
-	if m.hasResponse {
+	if m.nresults == 1 {
 		return outs[0].Interface(), nil
 	}
+	if m.nresults > 1 {
+		results := make([]interface{}, m.nresults)
+		for i := range results {
+			results[i] = outs[i].Interface()
+		}
+		return results, nil
+	}
 
 	// Otherwise no response.
 	return nil, nil
 }
+
+// panicError is a panic recovered from a method.
+type panicError struct {
+	method string
+	value  interface{}
+	stack  []byte
+}
+
+func (p *panicError) Error() string {
+	return fmt.Sprintf("%s: panic: %v", p.method, p.value)
+}
+
+// safeCall calls the function, recovering from a panic as a *panicError.
+func (m *method) safeCall(ins []reflect.Value) (outs []reflect.Value, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &panicError{method: m.name, value: v, stack: debug.Stack()}
+		}
+	}()
+	return m.Call(ins), nil
+}
+
+// callRaw calls a raw method with the params as they are, recovering from a
+// panic as a *panicError.
+func (m *method) callRaw(ctx context.Context, params json.RawMessage) (result interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &panicError{method: m.name, value: v, stack: debug.Stack()}
+		}
+	}()
+	if paramsKind(params) == 'n' {
+		params = nil
+	}
+	res, err := m.raw(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// namedArgs maps the members of a params object to positional arguments using
+// the method's param names.
+func (m *method) namedArgs(params json.RawMessage) ([]json.RawMessage, error) {
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal(params, &named); err != nil {
+		e := WrapError(fmt.Errorf("%s: %w", m.name, err))
+		e.Code = StatusInvalidParams
+		return nil, e
+	}
+
+	args := make([]json.RawMessage, 0, len(m.names))
+	for i, name := range m.names {
+		arg, ok := named[name]
+		delete(named, name)
+		if i == m.nargs {
+			// The variadic param is optional and expands to many arguments.
+			if !ok {
+				break
+			}
+			var rest []json.RawMessage
+			if err := json.Unmarshal(arg, &rest); err != nil {
+				return nil, &Error{
+					Code:    StatusInvalidParams,
+					Message: fmt.Sprintf("%s: param %q must be an array", m.name, name),
+				}
+			}
+			args = append(args, rest...)
+			break
+		}
+		if !ok {
+			return nil, &Error{
+				Code:    StatusInvalidParams,
+				Message: fmt.Sprintf("%s: missing param %q", m.name, name),
+			}
+		}
+		args = append(args, arg)
+	}
+
+	if len(named) > 0 {
+		unknown := make([]string, 0, len(named))
+		for name := range named {
+			unknown = append(unknown, name)
+		}
+		sort.Strings(unknown)
+		return nil, &Error{
+			Code:    StatusInvalidParams,
+			Message: fmt.Sprintf("%s: unknown param %q", m.name, unknown[0]),
+		}
+	}
+	return args, nil
+}
+
+// paramsKind returns the first byte of the params value, which identifies its
+// JSON type, or 0 if params are absent.
+func paramsKind(params json.RawMessage) byte {
+	params = bytes.TrimLeft(params, " \t\r\n")
+	if len(params) == 0 {
+		return 0
+	}
+	return params[0]
+}
+
+// isEmptyObject reports whether params is an object without members.
+func isEmptyObject(params json.RawMessage) bool {
+	params = bytes.Trim(params, " \t\r\n")
+	return len(params) >= 2 && len(bytes.Trim(params[1:len(params)-1], " \t\r\n")) == 0
+}
@@ -1,14 +1,20 @@
 package jsonrpc
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -43,8 +49,7 @@ func TestZeroHandler(t *testing.T) {
 			"id": null,
 			"error": {
 				"code": -32601,
-				"message": "No such method: unknown",
-				"data": null
+				"message": "No such method: unknown"
 			}
 		}`},
 	} {
@@ -83,7 +88,7 @@ func TestJSONRPC(t *testing.T) {
 	h.RegisterMethod("prefixecho", func(prefix string, s ...string) string {
 		return prefix + strings.Join(s, " ")
 	})
-	h.RegisterMethod("chan", func(c chan int) {})
+	h.RegisterMethod("int", func(n int) {})
 
 	// Prepare test cases.
 	type compare struct {
@@ -163,8 +168,7 @@ func TestJSONRPC(t *testing.T) {
 			"id": null,
 			"error": {
 				"code": -32603,
-				"message": "custom error",
-				"data": null
+				"message": "custom error"
 			}
 		}`},
 
@@ -174,8 +178,7 @@ func TestJSONRPC(t *testing.T) {
 			"id": null,
 			"error": {
 				"code": -32600,
-				"message": "EOF",
-				"data": null
+				"message": "EOF"
 			}
 		}`},
 		{`{
@@ -188,8 +191,7 @@ func TestJSONRPC(t *testing.T) {
 			"id": null,
 			"error": {
 				"code": -32700,
-				"message": "invalid character 'j' looking for beginning of object key string",
-				"data": null
+				"message": "invalid character 'j' looking for beginning of object key string"
 			}
 		}`},
 		{`{
@@ -201,8 +203,7 @@ func TestJSONRPC(t *testing.T) {
 			"id": null,
 			"error": {
 				"code": -32601,
-				"message": "No such method: unknown",
-				"data": null
+				"message": "No such method: unknown"
 			}
 		}`},
 		{`{
@@ -215,8 +216,7 @@ func TestJSONRPC(t *testing.T) {
 			"id": null,
 			"error": {
 				"code": -32600,
-				"message": "Invalid protocol: expected jsonrpc: 2.0",
-				"data": null
+				"message": "Invalid protocol: expected jsonrpc: 2.0"
 			}
 		}`},
 		{`{
@@ -229,8 +229,7 @@ func TestJSONRPC(t *testing.T) {
 			"id": null,
 			"error": {
 				"code": -32602,
-				"message": "echo: require 1 params",
-				"data": null
+				"message": "echo: require 1 params"
 			}
 		}`},
 		{`{
@@ -243,22 +242,90 @@ func TestJSONRPC(t *testing.T) {
 			"id": null,
 			"error": {
 				"code": -32602,
-				"message": "prefixecho: require at least 1 params",
-				"data": null
+				"message": "prefixecho: require at least 1 params"
 			}
 		}`},
 		{`{
 			"jsonrpc": "2.0",
 			"id": null,
-			"method": "chan",
+			"method": "int",
 			"params": "Hello world!"
 		}`, `{
 			"jsonrpc": "2.0",
 			"id": null,
 			"error": {
 				"code": -32602,
-				"message": "chan: json: cannot unmarshal string into Go value of type chan int",
-				"data": "Hello world!"
+				"message": "int: json: cannot unmarshal string into Go value of type int",
+				"data": {"param": 0, "value": "Hello world!"}
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": {},
+			"method": "echo",
+			"params": ["Hello world!"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": null,
+			"error": {
+				"code": -32600,
+				"message": "\"id\" is not a valid type: {}"
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": [1],
+			"method": "echo",
+			"params": ["Hello world!"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": null,
+			"error": {
+				"code": -32600,
+				"message": "\"id\" is not a valid type: [1]"
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 12345678901234567890,
+			"method": "echo",
+			"params": ["Hello world!"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 12345678901234567890,
+			"result": "Hello world!"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1e400,
+			"method": "echo",
+			"params": ["Hello world!"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1e400,
+			"result": "Hello world!"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1.5,
+			"method": "echo",
+			"params": ["Hello world!"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1.5,
+			"result": "Hello world!"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "Echoer.DelayEcho",
+			"params": 5
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"error": {
+				"code": -32602,
+				"message": "Echoer.DelayEcho: params must be array, object, or omitted"
 			}
 		}`},
 	} {
@@ -315,6 +382,52 @@ func expectJSON(t *testing.T, in *bytes.Buffer, expected string) {
 	}
 }
 
+func TestMultipleResults(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethod("divmod", func(a, b int) (int, int, error) {
+		if b == 0 {
+			return 0, 0, errors.New("division by zero")
+		}
+		return a / b, a % b, nil
+	})
+
+	for i, c := range []struct {
+		In  string
+		Out string
+	}{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "divmod",
+			"params": [7, 2]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": [3, 1]
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "divmod",
+			"params": [7, 0]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"error": {
+				"code": -32603,
+				"message": "division by zero"
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
 func TestAlternateEncoder(t *testing.T) {
 
 	type container struct {
@@ -392,6 +505,130 @@ func TestAlternateEncoder(t *testing.T) {
 	}
 }
 
+func TestIndent(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.Indent = "  "
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "Echoer.Echo",
+		"params": ["Hello world!"]
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	expected := `{
+  "jsonrpc": "2.0",
+  "id": 1,
+  "result": "Hello world!"
+}
+`
+	if got := w.Body.String(); got != expected {
+		t.Fatalf("expected: %s\ngot: %s", expected, got)
+	}
+}
+
+func TestDisableHTMLEscaping(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.DisableHTMLEscaping = true
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "Echoer.Echo",
+		"params": ["<b>Tom & Jerry</b>"]
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	expected := `{"jsonrpc":"2.0","id":1,"result":"<b>Tom & Jerry</b>"}
+`
+	if got := w.Body.String(); got != expected {
+		t.Fatalf("expected: %s\ngot: %s", expected, got)
+	}
+}
+
+func TestRawMessageResult(t *testing.T) {
+	cached := json.RawMessage(`{"name":"cached","tags":["a","b"]}`)
+
+	h := NewHandler()
+	h.RegisterMethod("cached", func() json.RawMessage {
+		return cached
+	})
+	h2 := NewHandler()
+	h2.Encoder = func(w io.Writer) Encoder {
+		return alt_json.NewEncoder(w)
+	}
+	h2.RegisterMethod("cached", func() json.RawMessage {
+		return cached
+	})
+
+	for i, dest := range []http.Handler{h, h2} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "cached"
+		}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		dest.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expected := `{"jsonrpc":"2.0","id":1,"result":{"name":"cached","tags":["a","b"]}}
+`
+		if got := w.Body.String(); got != expected {
+			t.Fatalf("expected: %s\ngot: %s", expected, got)
+		}
+	}
+}
+
+func TestAlternateDecoder(t *testing.T) {
+	h := NewHandler()
+	h.Decoder = func(r io.Reader) Decoder {
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		return dec
+	}
+	h.RegisterMethod("type", func(v interface{}) string {
+		return fmt.Sprintf("%T", v)
+	})
+
+	for i, c := range []struct {
+		In  string
+		Out string
+	}{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "type",
+			"params": [12345678901234567890]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": "json.Number"
+		}`},
+		{`[{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "type",
+			"params": [1.5]
+		}]`, `[{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"result": "json.Number"
+		}]`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
 func TestBidirectional(t *testing.T) {
 	t.Log("Running bidirectional test: out-of-sequence responses")
 	testBidirectional(t,
@@ -421,12 +658,25 @@ func TestBidirectional(t *testing.T) {
 			}`))
 			pw.Close()
 		},
-		`{"jsonrpc":"2.0","id":null,"error":{"code":-32601,"message":"No such method: error","data":null}}
+		`{"jsonrpc":"2.0","id":null,"error":{"code":-32601,"message":"No such method: error"}}
 {"jsonrpc":"2.0","id":2,"result":"Hello world!"}
 {"jsonrpc":"2.0","id":1,"result":"Hello world!"}
 `,
 	)
 
+	// Ensure an invalid ID does not end the stream.
+	t.Log("Running bidirectional test: invalid id")
+	testBidirectional(t,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{"jsonrpc": "2.0", "id": {}, "method": "Echoer.Echo", "params": ["first"]}`))
+			pw.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.DelayEcho", "params": ["second", 50]}`))
+			pw.Close()
+		},
+		`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"\"id\" is not a valid type: {}"}}
+{"jsonrpc":"2.0","id":1,"result":"second"}
+`,
+	)
+
 	// Ensure parse errors don't result in infinite loops.
 	t.Log("Running bidirectional test: parse error")
 	testBidirectional(t,
@@ -434,7 +684,7 @@ func TestBidirectional(t *testing.T) {
 			_, err := pw.Write([]byte(`[object Object]`))
 			pw.CloseWithError(err)
 		},
-		`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"invalid character 'o' looking for beginning of value","data":null}}
+		`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"invalid character 'o' looking for beginning of value"}}
 `,
 	)
 
@@ -452,14 +702,16 @@ func TestBidirectional(t *testing.T) {
 			pw.CloseWithError(errors.New("unexpected error from connection"))
 		},
 		`{"jsonrpc":"2.0","id":null,"result":"Notification"}
-{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"unexpected error from connection","data":null}}
+{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"unexpected error from connection"}}
 `,
 	)
 }
 
 func testBidirectional(t *testing.T, writer func(pw *io.PipeWriter), expected string) {
-	h := NewHandler(Echoer{})
+	testBidirectionalHandler(t, NewHandler(Echoer{}), writer, expected)
+}
 
+func testBidirectionalHandler(t *testing.T, h *Handler, writer func(pw *io.PipeWriter), expected string) {
 	var buf bytes.Buffer
 	pr, pw := io.Pipe()
 	stream := struct {
@@ -486,6 +738,131 @@ func testBidirectional(t *testing.T, writer func(pw *io.PipeWriter), expected st
 	}
 }
 
+func TestMaxConcurrency(t *testing.T) {
+	h := NewHandler(Echoer{})
+	h.MaxConcurrency = 1
+
+	t.Log("Running bidirectional test: serial responses")
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{
+				"jsonrpc": "2.0",
+				"id": 1,
+				"method": "Echoer.DelayEcho",
+				"params": ["Hello world!", 200]
+			}`))
+			pw.Write([]byte(`{
+				"jsonrpc": "2.0",
+				"id": 2,
+				"method": "Echoer.DelayEcho",
+				"params": ["Hello world!", 100]
+			}`))
+			pw.Close()
+		},
+		`{"jsonrpc":"2.0","id":1,"result":"Hello world!"}
+{"jsonrpc":"2.0","id":2,"result":"Hello world!"}
+`,
+	)
+}
+
+func TestConnNotify(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethod("subscribe", func(ctx context.Context, topic string) error {
+		c, ok := ConnFromContext(ctx)
+		if !ok {
+			return errors.New("not a connection")
+		}
+		if err := c.Notify("event", map[string]string{"topic": topic}); err != nil {
+			return err
+		}
+		return c.Notify("event", []int{1, 2})
+	})
+
+	t.Log("Running bidirectional test: server notifications")
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{
+				"jsonrpc": "2.0",
+				"id": 1,
+				"method": "subscribe",
+				"params": ["news"]
+			}`))
+			pw.Close()
+		},
+		`{"jsonrpc":"2.0","method":"event","params":{"topic":"news"}}
+{"jsonrpc":"2.0","method":"event","params":[1,2]}
+{"jsonrpc":"2.0","id":1,"result":null}
+`,
+	)
+
+	// Over HTTP there is no connection.
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "subscribe",
+		"params": ["news"]
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	expectJSON(t, w.Body, `{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"error": {
+			"code": -32603,
+			"message": "not a connection"
+		}
+	}`)
+}
+
+func TestStream(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethod("tail", func(ctx context.Context, n int) (int, error) {
+		for i := 1; i <= n; i++ {
+			if err := Stream(ctx, fmt.Sprintf("line %d", i)); err != nil {
+				return 0, err
+			}
+		}
+		return n, nil
+	})
+
+	t.Log("Running bidirectional test: streamed results")
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{
+				"jsonrpc": "2.0",
+				"id": "a",
+				"method": "tail",
+				"params": [2]
+			}`))
+			pw.Close()
+		},
+		`{"jsonrpc":"2.0","method":"rpc.stream","params":{"id":"a","result":"line 1"}}
+{"jsonrpc":"2.0","method":"rpc.stream","params":{"id":"a","result":"line 2"}}
+{"jsonrpc":"2.0","id":"a","result":2}
+`,
+	)
+
+	// Over HTTP there is no connection to stream over.
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "tail",
+		"params": [1]
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	expectJSON(t, w.Body, `{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"error": {
+			"code": -32603,
+			"message": "jsonrpc: cannot stream without a connection and a request ID"
+		}
+	}`)
+}
+
 func TestRequestInterceptor(t *testing.T) {
 	h := NewHandler(&Echoer{})
 	h.RequestInterceptor = func(ctx context.Context, req *Request) error {
@@ -520,8 +897,7 @@ func TestRequestInterceptor(t *testing.T) {
 			"id": null,
 			"error": {
 				"code": -32603,
-				"message": "forbidden",
-				"data": null
+				"message": "forbidden"
 			}
 		}`},
 	} {
@@ -571,8 +947,7 @@ func TestResponseInterceptor(t *testing.T) {
 			"id": null,
 			"error": {
 				"code": 403,
-				"message": "forbidden",
-				"data": null
+				"message": "forbidden"
 			}
 		}`},
 	} {
@@ -584,3 +959,1918 @@ func TestResponseInterceptor(t *testing.T) {
 		expectJSON(t, w.Body, c.Out)
 	}
 }
+
+func TestResultTransformer(t *testing.T) {
+	type account struct {
+		ID         int    `json:"id,omitempty"`
+		Name       string `json:"name"`
+		InternalID string `json:"internalId,omitempty"`
+	}
+	h := NewHandler()
+	h.RegisterMethod("account", func(id int) account {
+		return account{ID: id, Name: "alice", InternalID: "db-7"}
+	})
+	h.RegisterMethod("fail", func() (account, error) {
+		return account{}, errors.New("failed")
+	})
+	h.ResultTransformer = func(ctx context.Context, method string, result interface{}) (interface{}, error) {
+		a, ok := result.(account)
+		if !ok {
+			return result, nil
+		}
+		if a.ID == 0 {
+			return nil, &Error{Code: 403, Message: "forbidden"}
+		}
+		a.InternalID = ""
+		return a, nil
+	}
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{"jsonrpc": "2.0", "id": 1, "method": "account", "params": [7]}`,
+			`{"jsonrpc": "2.0", "id": 1, "result": {"id": 7, "name": "alice"}}`},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "account", "params": [0]}`,
+			`{"jsonrpc": "2.0", "id": 1, "error": {"code": 403, "message": "forbidden"}}`},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "fail"}`,
+			`{"jsonrpc": "2.0", "id": 1, "error": {"code": -32603, "message": "failed"}}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	h := NewHandler(&Echoer{})
+
+	// Prepare test cases.
+	type compare struct {
+		In     string
+		Out    string
+		Status int
+	}
+	for i, c := range []compare{
+		{`[
+			{"jsonrpc": "2.0", "id": 1, "method": "Echoer.DelayEcho", "params": ["first", 100]},
+			{"jsonrpc": "2.0", "method": "Echoer.Echo", "params": ["notification"]},
+			{"jsonrpc": "2.0", "id": 2, "method": "Echoer.Echo", "params": ["second"]},
+			{"jsonrpc": "2.0", "id": 3, "method": "unknown"}
+		]`, `[
+			{"jsonrpc": "2.0", "id": 1, "result": "first"},
+			{"jsonrpc": "2.0", "id": 2, "result": "second"},
+			{"jsonrpc": "2.0", "id": 3, "error": {"code": -32601, "message": "No such method: unknown"}}
+		]`, http.StatusOK},
+		{`[1, 2]`, `[
+			{"jsonrpc": "2.0", "id": null, "error": {"code": -32600, "message": "json: cannot unmarshal number into Go value of type jsonrpc.request"}},
+			{"jsonrpc": "2.0", "id": null, "error": {"code": -32600, "message": "json: cannot unmarshal number into Go value of type jsonrpc.request"}}
+		]`, http.StatusOK},
+		{` []`, `{
+			"jsonrpc": "2.0",
+			"id": null,
+			"error": {"code": -32600, "message": "Invalid request: empty batch"}
+		}`, http.StatusOK},
+		{`[
+			{"jsonrpc": "2.0", "method": "Echoer.Echo", "params": ["first"]},
+			{"jsonrpc": "2.0", "method": "Echoer.Echo", "params": ["second"]}
+		]`, ``, http.StatusNoContent},
+		{`[
+			{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["first"]},
+			{"jsonrpc": "2.0", "method"
+		]`, `{
+			"jsonrpc": "2.0",
+			"id": null,
+			"error": {"code": -32700, "message": "invalid character ']' after object key"}
+		}`, http.StatusOK},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		if w.Code != c.Status {
+			t.Fatalf("expected status %d, got %d", c.Status, w.Code)
+		}
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestBatchConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	var running, peak int
+	h := NewHandler()
+	h.BatchConcurrency = 2
+	h.RegisterMethod("delay", func(s string, ms int) string {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return s
+	})
+
+	// Later requests finish first, but responses keep the request order.
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`[
+		{"jsonrpc": "2.0", "id": 1, "method": "delay", "params": ["first", 60]},
+		{"jsonrpc": "2.0", "id": 2, "method": "delay", "params": ["second", 40]},
+		{"jsonrpc": "2.0", "method": "delay", "params": ["notification", 30]},
+		{"jsonrpc": "2.0", "id": 3, "method": "delay", "params": ["third", 20]},
+		{"jsonrpc": "2.0", "id": 4, "method": "delay", "params": ["fourth", 0]}
+	]`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	expectJSON(t, w.Body, `[
+		{"jsonrpc": "2.0", "id": 1, "result": "first"},
+		{"jsonrpc": "2.0", "id": 2, "result": "second"},
+		{"jsonrpc": "2.0", "id": 3, "result": "third"},
+		{"jsonrpc": "2.0", "id": 4, "result": "fourth"}
+	]`)
+	if peak > 2 {
+		t.Fatalf("expected at most 2 concurrent calls, got %d", peak)
+	}
+}
+
+func TestBatchAbort(t *testing.T) {
+	h := NewHandler()
+	h.BatchConcurrency = 1
+	h.BatchAbort = func(e *Error) bool {
+		return e.Code == StatusUnauthorized
+	}
+	h.RegisterMethod("check", func(ok bool) error {
+		if !ok {
+			return &Error{Code: StatusUnauthorized, Message: "Unauthorized"}
+		}
+		return nil
+	})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`[
+		{"jsonrpc": "2.0", "id": 1, "method": "check", "params": [true]},
+		{"jsonrpc": "2.0", "id": 2, "method": "check", "params": ["invalid"]},
+		{"jsonrpc": "2.0", "id": 3, "method": "check", "params": [false]},
+		{"jsonrpc": "2.0", "id": 4, "method": "check", "params": [true]},
+		{"jsonrpc": "2.0", "id": 5, "method": "unknown"}
+	]`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	expectJSON(t, w.Body, `[
+		{"jsonrpc": "2.0", "id": 1, "result": null},
+		{"jsonrpc": "2.0", "id": 2, "error": {"code": -32602, "message": "check: json: cannot unmarshal string into Go value of type bool", "data": {"param": 0, "value": "invalid"}}},
+		{"jsonrpc": "2.0", "id": 3, "error": {"code": -32001, "message": "Unauthorized"}},
+		{"jsonrpc": "2.0", "id": 4, "error": {"code": -32002, "message": "Batch aborted"}},
+		{"jsonrpc": "2.0", "id": 5, "error": {"code": -32601, "message": "No such method: unknown"}}
+	]`)
+}
+
+func TestNotificationError(t *testing.T) {
+	h := NewHandler()
+	h.HTTPErrorStatus = true
+	h.RegisterMethod("fail", func(n int) error {
+		return errors.New("failed")
+	})
+	h.RegisterMethod("panic", func() {
+		panic("oops")
+	})
+
+	// A notification never receives a response, even when it fails.
+	for i, in := range []string{
+		`{"jsonrpc": "2.0", "method": "fail", "params": [1]}`,
+		`{"jsonrpc": "2.0", "method": "fail", "params": ["one"]}`,
+		`{"jsonrpc": "2.0", "method": "panic"}`,
+		`{"jsonrpc": "2.0", "method": "unknown"}`,
+		`[{"jsonrpc": "2.0", "method": "fail", "params": [1]}, {"jsonrpc": "2.0", "method": "unknown"}]`,
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(in))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("expected status %d, got %d", http.StatusNoContent, w.Code)
+		}
+		if w.Body.Len() != 0 {
+			t.Fatalf("expected no body, got %s", w.Body)
+		}
+	}
+
+	t.Log("Running bidirectional test: failed notifications")
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{"jsonrpc": "2.0", "method": "fail", "params": [1]}`))
+			pw.Write([]byte(`{"jsonrpc": "2.0", "method": "fail", "params": ["one"]}`))
+			pw.Write([]byte(`{"jsonrpc": "2.0", "method": "panic"}`))
+			pw.Write([]byte(`{"jsonrpc": "2.0", "method": "unknown"}`))
+			pw.Close()
+		},
+		``,
+	)
+}
+
+func TestMaxBatchSize(t *testing.T) {
+	called := make(chan string, 3)
+	h := NewHandler()
+	h.MaxBatchSize = 2
+	h.RegisterMethod("echo", func(s string) string {
+		called <- s
+		return s
+	})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`[
+		{"jsonrpc": "2.0", "id": 1, "method": "echo", "params": ["first"]},
+		{"jsonrpc": "2.0", "id": 2, "method": "echo", "params": ["second"]},
+		{"jsonrpc": "2.0", "id": 3, "method": "echo", "params": ["third"]}
+	]`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	expectJSON(t, w.Body, `{
+		"jsonrpc": "2.0",
+		"id": null,
+		"error": {"code": -32600, "message": "Invalid request: batch exceeds 2 requests"}
+	}`)
+	if len(called) != 0 {
+		t.Fatalf("expected no calls, got %d", len(called))
+	}
+}
+
+type customChan chan int
+
+func (c *customChan) UnmarshalJSON(data []byte) error {
+	return nil
+}
+
+type badRegistration struct{}
+
+func (badRegistration) Good() {}
+
+func (badRegistration) Bad(s string, ctx context.Context) {}
+
+func TestTryRegister(t *testing.T) {
+	var h Handler
+	if err := h.TryRegisterMethod("good", func() {}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := h.TryRegisterMethod("notfunc", 5); err == nil {
+		t.Fatal("expected error registering a non-function")
+	}
+	if err := h.TryRegisterMethod("lastctx", func(s string, ctx context.Context) {}); err == nil {
+		t.Fatal("expected error registering a context.Context in the last position")
+	}
+	if err := h.TryRegisterMethod("variadicctx", func(ctx ...context.Context) {}); err == nil {
+		t.Fatal("expected error registering a variadic context.Context")
+	}
+	for i, fn := range []interface{}{
+		func(c chan int) {},
+		func(c ...chan int) {},
+		func(f func()) {},
+		func(m map[string]func()) {},
+		func(c []complex128) {},
+	} {
+		if err := h.TryRegisterMethod("unmarshalable", fn); err == nil {
+			t.Fatalf("case %d: expected error registering %T", i, fn)
+		}
+	}
+	if err := h.TryRegisterMethod("unmarshaler", func(c customChan) {}); err != nil {
+		t.Fatalf("unexpected error registering a type with UnmarshalJSON: %v", err)
+	}
+	if err := h.TryRegister(badRegistration{}); err == nil {
+		t.Fatal("expected error registering a receiver with an invalid method")
+	}
+	if h.HasMethod("badRegistration.Good") {
+		t.Fatal("valid method was registered despite an invalid sibling")
+	}
+
+	(func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("invalid registration did not panic")
+			}
+		}()
+		h.RegisterMethod("notfunc", 5)
+	})()
+}
+
+type Greeter interface {
+	Greet(name string) string
+}
+
+type englishGreeter struct{}
+
+func (englishGreeter) Greet(name string) string {
+	return "Hello " + name
+}
+
+type GreeterService struct {
+	Greeter
+}
+
+func (*GreeterService) Farewell(name string) string {
+	return "Goodbye " + name
+}
+
+func TestDisallowRedefine(t *testing.T) {
+	var h Handler
+	h.RegisterMethod("echo", func(s string) string { return s })
+	if err := h.TryRegisterMethod("echo", func(s string) string { return s }); err != nil {
+		t.Fatalf("unexpected error redefining a method by default: %v", err)
+	}
+
+	h.DisallowRedefine = true
+	if err := h.TryRegisterMethod("echo", func(s string) string { return s }); err == nil {
+		t.Fatal("expected error redefining a method")
+	}
+	if err := h.TryRegisterMethodNamed("echo", func(s string) string { return s }, "s"); err == nil {
+		t.Fatal("expected error redefining a named method")
+	}
+	if err := h.TryRegister(&Echoer{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := h.TryRegister(&Echoer{}); err == nil {
+		t.Fatal("expected error redefining a receiver's methods")
+	}
+	h.Unregister("echo")
+	if err := h.TryRegisterMethod("echo", func(s string) string { return s }); err != nil {
+		t.Fatalf("unexpected error registering an unregistered name: %v", err)
+	}
+
+	(func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("redefinition did not panic")
+			}
+		}()
+		h.RegisterMethod("echo", func(s string) string { return s })
+	})()
+}
+
+func TestMethodNameValidation(t *testing.T) {
+	h := NewHandler()
+	echo := func(s string) string { return s }
+	for i, name := range []string{"", " echo", "echo\n", "ec\x00ho", "rpc.echo"} {
+		t.Logf("Running test %d", i)
+		if err := h.TryRegisterMethod(name, echo); err == nil {
+			t.Fatalf("expected error registering %q", name)
+		}
+	}
+	if err := h.TryRegisterName("rpc", &Echoer{}); err == nil {
+		t.Fatal("expected error registering a receiver under a reserved name")
+	}
+	h.RegisterMethod("echo", echo)
+	if err := h.Alias("rpc.echo", "echo"); err == nil {
+		t.Fatal("expected error aliasing a reserved name")
+	}
+	if h.HasMethod("rpc.echo") {
+		t.Fatal("expected reserved name not to be registered")
+	}
+
+	// Reserved names may be allowed explicitly.
+	h.AllowReservedNames = true
+	if err := h.TryRegisterMethod("rpc.echo", echo); err != nil {
+		t.Fatalf("unexpected error registering an allowed reserved name: %v", err)
+	}
+
+	// The discover method is always allowed.
+	h = NewHandler()
+	h.EnableDiscovery()
+	if !h.HasMethod(DiscoverMethod) {
+		t.Fatalf("expected %s to be registered", DiscoverMethod)
+	}
+}
+
+func TestRegisterMethodSet(t *testing.T) {
+	h := NewHandler()
+
+	// Methods promoted from an embedded interface are registered, as are
+	// methods with pointer receivers.
+	if err := h.TryRegisterName("svc", &GreeterService{englishGreeter{}}); err != nil {
+		t.Fatal(err)
+	}
+	// Methods of an interface are registered under the interface's name.
+	var g Greeter = englishGreeter{}
+	if err := h.TryRegister(&g); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"svc.Greet", "svc.Farewell", "Greeter.Greet"} {
+		if !h.HasMethod(name) {
+			t.Fatalf("expected %s to be registered", name)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "Greeter.Greet",
+		"params": ["world"]
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	expectJSON(t, w.Body, `{"jsonrpc": "2.0", "id": 1, "result": "Hello world"}`)
+
+	// Pointer receiver methods are not in the method set of a value.
+	if err := h.TryRegisterName("value", GreeterService{englishGreeter{}}); err != nil {
+		t.Fatal(err)
+	}
+	if h.HasMethod("value.Farewell") {
+		t.Fatal("expected value.Farewell not to be registered")
+	}
+
+	for i, rcvr := range []interface{}{
+		nil,
+		struct{}{},
+		struct{ Greeter }{englishGreeter{}},
+		new(Greeter),
+	} {
+		t.Logf("Running test %d", i)
+		if err := h.TryRegister(rcvr); err == nil {
+			t.Fatalf("expected error registering %#v", rcvr)
+		}
+	}
+}
+
+func TestNameMapper(t *testing.T) {
+	h := NewHandler()
+	h.NameMapper = func(typeName, methodName string) string {
+		return strings.ToLower(typeName) + "." + strings.ToLower(methodName[:1]) + methodName[1:]
+	}
+	h.Register(&Echoer{})
+
+	for _, name := range []string{"echoer.echo", "echoer.delayEcho"} {
+		if !h.HasMethod(name) {
+			t.Fatalf("expected %s to be registered", name)
+		}
+	}
+	if h.HasMethod("Echoer.Echo") {
+		t.Fatal("expected Echoer.Echo not to be registered")
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	if !h.HasMethod("Echoer.Echo") {
+		t.Fatal("expected Echoer.Echo to be registered")
+	}
+	if !h.Unregister("Echoer.Echo") {
+		t.Fatal("expected Unregister to report a registered method")
+	}
+	if h.HasMethod("Echoer.Echo") {
+		t.Fatal("expected Echoer.Echo to be unregistered")
+	}
+	if h.Unregister("Echoer.Echo") {
+		t.Fatal("expected Unregister to report an unregistered method")
+	}
+
+	// Hot-swap a method while requests are being served.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			h.RegisterMethod("flag", func() bool { return true })
+			h.Unregister("flag")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "flag"
+		}`))
+		req.Header.Set("Content-Type", "application/json")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	<-done
+}
+
+func TestNamedParams(t *testing.T) {
+	type options struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	h := NewHandler()
+	h.RegisterMethod("repeat", func(ctx context.Context, opts options) string {
+		return strings.Repeat(opts.Name, opts.Count)
+	})
+	h.RegisterMethod("keys", func(m map[string]int) int {
+		return len(m)
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "repeat",
+			"params": {"name": "x", "count": 3}
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": "xxx"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "repeat",
+			"params": [{"name": "y", "count": 2}]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"result": "yy"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"method": "keys",
+			"params": {"a": 1, "b": 2}
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"result": 2
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestRegisterMethodNamed(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethodNamed("dial", func(host string, port int) string {
+		return fmt.Sprintf("%s:%d", host, port)
+	}, "host", "port")
+	h.RegisterMethodNamed("join", func(ctx context.Context, sep string, s ...string) string {
+		return strings.Join(s, sep)
+	}, "sep", "s")
+
+	if err := h.TryRegisterMethodNamed("bad", func(a, b string) {}, "a"); err == nil {
+		t.Fatal("expected error registering with too few param names")
+	}
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "dial",
+			"params": {"port": 8080, "host": "a"}
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": "a:8080"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "dial",
+			"params": ["b", 80]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"result": "b:80"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"method": "dial",
+			"params": {"host": "a"}
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"error": {
+				"code": -32602,
+				"message": "dial: missing param \"port\""
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 4,
+			"method": "dial",
+			"params": {"host": "a", "port": 80, "scheme": "http"}
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 4,
+			"error": {
+				"code": -32602,
+				"message": "dial: unknown param \"scheme\""
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 5,
+			"method": "join",
+			"params": {"sep": "-", "s": ["a", "b", "c"]}
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 5,
+			"result": "a-b-c"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 6,
+			"method": "join",
+			"params": {"sep": "-"}
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 6,
+			"result": ""
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 7,
+			"method": "dial",
+			"params": {"host": "a", "port": "http"}
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 7,
+			"error": {
+				"code": -32602,
+				"message": "dial: json: cannot unmarshal string into Go value of type int",
+				"data": {"param": 1, "name": "port", "value": "http"}
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 8,
+			"method": "join",
+			"params": {"sep": "-", "s": ["a", 2]}
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 8,
+			"error": {
+				"code": -32602,
+				"message": "join: json: cannot unmarshal number into Go value of type string",
+				"data": {"param": 2, "name": "s", "value": 2}
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestRegisterMethodOptional(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethodOptional("search", func(ctx context.Context, query string, limit, offset int) string {
+		return fmt.Sprintf("%s %d %d", query, limit, offset)
+	}, 1)
+
+	if err := h.TryRegisterMethodOptional("bad", func(a string) {}, 2); err == nil {
+		t.Fatal("expected error registering with too many required params")
+	}
+	if err := h.TryRegisterMethodOptional("bad", func(a string, b ...string) {}, 1); err == nil {
+		t.Fatal("expected error registering a variadic method with optional params")
+	}
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "search",
+			"params": ["foo"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": "foo 0 0"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "search",
+			"params": ["foo", 10]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"result": "foo 10 0"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"method": "search",
+			"params": ["foo", 10, 20]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"result": "foo 10 20"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 4,
+			"method": "search",
+			"params": []
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 4,
+			"error": {
+				"code": -32602,
+				"message": "search: require 1 to 3 params"
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 5,
+			"method": "search",
+			"params": ["foo", 10, 20, 30]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 5,
+			"error": {
+				"code": -32602,
+				"message": "search: require 1 to 3 params"
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	var recovered interface{}
+	h := NewHandler()
+	h.PanicHandler = func(ctx context.Context, method string, v interface{}, stack []byte) {
+		recovered = v
+	}
+	h.RegisterMethod("explode", func(s string) string {
+		panic(s)
+	})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "explode",
+		"params": ["oops"]
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	expectJSON(t, w.Body, `{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"error": {
+			"code": -32603,
+			"message": "explode: panic: oops"
+		}
+	}`)
+	if recovered != "oops" {
+		t.Fatalf("expected PanicHandler to receive %q, got %v", "oops", recovered)
+	}
+
+	// In debug mode the stack is included.
+	h.Debug = true
+	req = httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "explode",
+		"params": ["oops"]
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var res struct {
+		Error *Error
+	}
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if stack, _ := res.Error.Data.(string); !strings.Contains(stack, "goroutine") {
+		t.Fatalf("expected stack trace in data, got: %v", res.Error.Data)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+	h := NewHandler(&Echoer{})
+	h.Use(func(next MethodFunc) MethodFunc {
+		return func(ctx context.Context, req Request) (interface{}, error) {
+			calls = append(calls, "outer:"+req.Method)
+			return next(ctx, req)
+		}
+	}, func(next MethodFunc) MethodFunc {
+		return func(ctx context.Context, req Request) (interface{}, error) {
+			calls = append(calls, "inner:"+req.Method)
+			if req.Method == "Echoer.DelayEcho" {
+				return nil, errors.New("forbidden")
+			}
+			result, err := next(ctx, req)
+			if s, ok := result.(string); ok {
+				result = strings.ToUpper(s)
+			}
+			return result, err
+		}
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": null,
+			"method": "Echoer.Echo",
+			"params": "Hello world!"
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": null,
+			"result": "HELLO WORLD!"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": null,
+			"method": "Echoer.DelayEcho",
+			"params": ["Hello world!", 200]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": null,
+			"error": {
+				"code": -32603,
+				"message": "forbidden"
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+
+	expected := "outer:Echoer.Echo inner:Echoer.Echo outer:Echoer.DelayEcho inner:Echoer.DelayEcho"
+	if got := strings.Join(calls, " "); got != expected {
+		t.Fatalf("expected: %s\ngot: %s", expected, got)
+	}
+}
+
+func TestMethodTimeout(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.MethodTimeout = 50 * time.Millisecond
+	h.RegisterMethod("wait", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "Echoer.DelayEcho",
+			"params": ["Hello world!", 10]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": "Hello world!"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "wait"
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"error": {
+				"code": -32004,
+				"message": "Deadline exceeded"
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"method": "Echoer.DelayEcho",
+			"params": ["Hello world!", 500]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"error": {
+				"code": -32004,
+				"message": "Deadline exceeded"
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		start := time.Now()
+		h.ServeHTTP(w, req)
+		if d := time.Since(start); d > 250*time.Millisecond {
+			t.Fatalf("method was not abandoned after timeout: took %v", d)
+		}
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestCancelledError(t *testing.T) {
+	h := NewHandler()
+	h.MaskInternalErrors = true
+	h.RegisterMethod("cancelled", func() error {
+		return fmt.Errorf("querying: %w", context.Canceled)
+	})
+	h.RegisterMethod("deadline", func() error {
+		return context.DeadlineExceeded
+	})
+	h.RegisterMethod("fail", func() error {
+		return errors.New("failed")
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		Method string
+		Out    string
+	}
+	for i, c := range []compare{
+		{"cancelled", `{"jsonrpc": "2.0", "id": 1, "error": {"code": -32003, "message": "Request cancelled"}}`},
+		{"deadline", `{"jsonrpc": "2.0", "id": 1, "error": {"code": -32004, "message": "Deadline exceeded"}}`},
+		{"fail", `{"jsonrpc": "2.0", "id": 1, "error": {"code": -32603, "message": "Internal error"}}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "`+c.Method+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.ReadTimeout = 50 * time.Millisecond
+	h.NDJSON = true
+	h.RegisterMethod("sleep", func(ctx context.Context) error {
+		select {
+		case <-time.After(150 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	// Prepare test cases. Each body is sent over a fresh connection, and then
+	// the client stalls unless the body is complete.
+	type compare struct {
+		In          string
+		ContentType string
+		Complete    bool
+		Status      int
+		Out         string
+	}
+	for i, c := range []compare{
+		{`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]}`, "application/json", true,
+			http.StatusOK, `{"jsonrpc": "2.0", "id": 1, "result": "Hello world!"}`},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "sleep"}`, "application/json", true,
+			http.StatusOK, `{"jsonrpc": "2.0", "id": 1, "result": null}`},
+		{`[{"jsonrpc": "2.0", "id": 1, "method": "sleep"}]`, "application/json", true,
+			http.StatusOK, `[{"jsonrpc": "2.0", "id": 1, "result": null}]`},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo"`, "application/json", false,
+			http.StatusRequestTimeout, ``},
+		{`[{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]},`, "application/json", false,
+			http.StatusRequestTimeout, ``},
+		{``, "application/x-ndjson", false,
+			http.StatusRequestTimeout, ``},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]}` + "\n", "application/x-ndjson", false,
+			http.StatusOK, `{"jsonrpc": "2.0", "id": 1, "result": "Hello world!"}`},
+	} {
+		t.Logf("Running test %d", i)
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+		// An incomplete body promises more than it sends.
+		length := len(c.In)
+		if !c.Complete {
+			length += 100
+		}
+		start := time.Now()
+		fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: test\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", c.ContentType, length, c.In)
+
+		br := bufio.NewReader(conn)
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != c.Status {
+			t.Fatalf("expected status %d, got %d", c.Status, res.StatusCode)
+		}
+		if c.Status == http.StatusOK {
+			expectJSON(t, bytes.NewBuffer(body), c.Out)
+		}
+		if c.Complete {
+			conn.Close()
+			continue
+		}
+
+		// The server must close the connection rather than keep waiting on
+		// the stalled body.
+		if _, err := io.Copy(io.Discard, br); err != nil {
+			t.Fatalf("expected the connection to be closed: %v", err)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Fatalf("connection was not closed after timeout: took %v", d)
+		}
+		conn.Close()
+	}
+}
+
+// unwrapWriter is a ResponseWriter wrapped by middleware.
+type unwrapWriter struct {
+	http.ResponseWriter
+}
+
+func (w unwrapWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestReadTimeoutUnwrap(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.ReadTimeout = 50 * time.Millisecond
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(unwrapWriter{w}, r)
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{")
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusRequestTimeout {
+		t.Fatalf("expected status %d, got %d", http.StatusRequestTimeout, res.StatusCode)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.Fallback = func(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+		switch method {
+		case "forbidden":
+			return nil, &Error{Code: 403, Message: "forbidden"}
+		case "unknown":
+			return nil, ErrMethodNotFound
+		}
+		return map[string]interface{}{
+			"method": method,
+			"params": params,
+		}, nil
+	}
+	var notFound []string
+	h.OnCallEnd = func(method string, d time.Duration, err *Error) {
+		if errors.Is(err, ErrMethodNotFound) {
+			notFound = append(notFound, method)
+		}
+	}
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "Echoer.Echo",
+			"params": ["Hello world!"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": "Hello world!"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "upstream.echo",
+			"params": ["Hello world!"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"result": {"method": "upstream.echo", "params": ["Hello world!"]}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"method": "forbidden"
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"error": {
+				"code": 403,
+				"message": "forbidden"
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 4,
+			"method": "unknown"
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 4,
+			"error": {
+				"code": -32601,
+				"message": "No such method: unknown"
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+
+	if len(notFound) != 1 || notFound[0] != "unknown" {
+		t.Fatalf("expected method not found for unknown, got %v", notFound)
+	}
+}
+
+func TestTimeoutHeader(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethod("deadline", func(ctx context.Context) bool {
+		_, ok := ctx.Deadline()
+		return ok
+	})
+
+	for i, c := range []struct {
+		Timeout string
+		Out     string
+	}{
+		{"", `{"jsonrpc": "2.0", "id": 1, "result": false}`},
+		{"500ms", `{"jsonrpc": "2.0", "id": 1, "result": true}`},
+		{"soon", `{"jsonrpc": "2.0", "id": 1, "result": false}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "deadline"
+		}`))
+		req.Header.Set("Content-Type", "application/json")
+		if c.Timeout != "" {
+			req.Header.Set(TimeoutHeader, c.Timeout)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestContextFunc(t *testing.T) {
+	type tenantKey struct{}
+	h := NewHandler()
+	h.ContextFunc = func(ctx context.Context) context.Context {
+		if _, ok := HTTPRequest(ctx); ok {
+			return context.WithValue(ctx, tenantKey{}, "http")
+		}
+		return context.WithValue(ctx, tenantKey{}, "conn")
+	}
+	h.RegisterMethod("tenant", func(ctx context.Context) string {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		return tenant
+	})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "tenant"
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	expectJSON(t, w.Body, `{"jsonrpc": "2.0", "id": 1, "result": "http"}`)
+
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{
+				"jsonrpc": "2.0",
+				"id": 1,
+				"method": "tenant"
+			}`))
+			pw.Close()
+		},
+		`{"jsonrpc":"2.0","id":1,"result":"conn"}
+`,
+	)
+}
+
+func TestPropagateHeaders(t *testing.T) {
+	h := NewHandler()
+	h.PropagateHeaders("x-request-id", "Traceparent")
+	h.RegisterMethod("requestID", func(ctx context.Context) string {
+		r, _ := HTTPRequest(ctx)
+		return r.Header.Get("X-Request-ID")
+	})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "requestID"
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", "abc123")
+	req.Header.Set("X-Other", "ignored")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	expectJSON(t, w.Body, `{"jsonrpc": "2.0", "id": 1, "result": "abc123"}`)
+
+	if got := w.Header().Get("X-Request-ID"); got != "abc123" {
+		t.Fatalf("expected X-Request-ID %q, got %q", "abc123", got)
+	}
+	if _, ok := w.Header()["Traceparent"]; ok {
+		t.Fatal("expected no Traceparent header")
+	}
+	if _, ok := w.Header()["X-Other"]; ok {
+		t.Fatal("expected no X-Other header")
+	}
+}
+
+func TestClientDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var entry LogEntry
+	h := NewHandler(&Echoer{})
+	h.Logger = func(e LogEntry) {
+		entry = e
+	}
+	h.Use(func(next MethodFunc) MethodFunc {
+		return func(ctx context.Context, req Request) (interface{}, error) {
+			// The client disconnects while the method is running.
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				cancel()
+			}()
+			return next(ctx, req)
+		}
+	})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "Echoer.DelayEcho",
+		"params": ["Hello world!", 50]
+	}`))
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Body.Len() != 0 {
+		t.Fatalf("expected no response, got: %s", w.Body.String())
+	}
+	if entry.SendError != context.Canceled {
+		t.Fatalf("expected send error %v, got %v", context.Canceled, entry.SendError)
+	}
+}
+
+func TestHTTPErrorStatus(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.HTTPErrorStatus = true
+
+	for i, c := range []struct {
+		In     string
+		Status int
+	}{
+		{`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]}`, http.StatusOK},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "unknown"}`, http.StatusOK},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": []}`, http.StatusOK},
+		{`{"jsonrpc": "2.0", "id": 1, "method"`, http.StatusBadRequest},
+		{`{"jsonrpc": "1.0", "id": 1, "method": "Echoer.Echo"}`, http.StatusBadRequest},
+		{`[]`, http.StatusBadRequest},
+		{`[{"jsonrpc": "1.0", "id": 1, "method": "Echoer.Echo"}]`, http.StatusOK},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		if w.Code != c.Status {
+			t.Fatalf("expected status %d, got %d", c.Status, w.Code)
+		}
+		if w.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("expected a JSON body, got Content-Type %q", w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestAllowV1(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.AllowV1 = true
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"id": 1,
+			"method": "Echoer.Echo",
+			"params": ["Hello world!"]
+		}`, `{
+			"id": 1,
+			"result": "Hello world!",
+			"error": null
+		}`},
+		{`{
+			"id": 2,
+			"method": "unknown",
+			"params": []
+		}`, `{
+			"id": 2,
+			"result": null,
+			"error": {
+				"code": -32601,
+				"message": "No such method: unknown"
+			}
+		}`},
+		{`{
+			"id": null,
+			"method": "Echoer.Echo",
+			"params": ["Hello world!"]
+		}`, ``},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"method": "Echoer.Echo",
+			"params": ["Hello world!"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"result": "Hello world!"
+		}`},
+		{`{
+			"jsonrpc": "1.0",
+			"id": 4,
+			"method": "Echoer.Echo",
+			"params": ["Hello world!"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 4,
+			"error": {
+				"code": -32600,
+				"message": "Invalid protocol: expected jsonrpc: 2.0"
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		if c.Out == "" {
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("expected no content for a notification, got status %d", w.Code)
+			}
+			continue
+		}
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestByteOrderMark(t *testing.T) {
+	h := NewHandler(&Echoer{})
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{"\ufeff" + `{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]}`,
+			`{"jsonrpc": "2.0", "id": 1, "result": "Hello world!"}`},
+		{"\ufeff" + `[{"jsonrpc": "2.0", "id": 2, "method": "Echoer.Echo", "params": ["Hello world!"]}]`,
+			`[{"jsonrpc": "2.0", "id": 2, "result": "Hello world!"}]`},
+		{"\xef" + `{"jsonrpc": "2.0", "id": 3, "method": "Echoer.Echo", "params": ["Hello world!"]}`,
+			`{"jsonrpc": "2.0", "id": null, "error": {"code": -32700, "message": "invalid character '\\xef' looking for beginning of value"}}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+
+	t.Log("Running bidirectional test: byte order mark")
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte("\ufeff" + `{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]}`))
+			pw.Close()
+		},
+		`{"jsonrpc":"2.0","id":1,"result":"Hello world!"}
+`,
+	)
+}
+
+func TestNDJSON(t *testing.T) {
+	h := NewHandler(&Echoer{})
+
+	body := `{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["first"]}
+{"jsonrpc": "2.0", "method": "Echoer.Echo", "params": ["notification"]}
+{"jsonrpc": "2.0", "id": 2, "method": "Echoer.Echo", "params": ["second"]}
+{"jsonrpc": "2.0", "id": 3, "method": "unknown"}
+`
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected status %d without NDJSON, got %d", http.StatusUnsupportedMediaType, w.Code)
+	}
+
+	h.NDJSON = true
+	for i, c := range []struct {
+		In  string
+		Out string
+	}{
+		{body, `{"jsonrpc":"2.0","id":1,"result":"first"}
+{"jsonrpc":"2.0","id":2,"result":"second"}
+{"jsonrpc":"2.0","id":3,"error":{"code":-32601,"message":"No such method: unknown"}}
+`},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["first"]}
+{"jsonrpc": "2.0", "id": 2, "method"
+{"jsonrpc": "2.0", "id": 3, "method": "Echoer.Echo", "params": ["third"]}`, `{"jsonrpc":"2.0","id":1,"result":"first"}
+{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"invalid character '{' after object key"}}
+`},
+		{`{"jsonrpc": "2.0", "method": "Echoer.Echo", "params": ["notification"]}`, ``},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		if got := w.Body.String(); got != c.Out {
+			t.Fatalf("expected: %s\ngot: %s", c.Out, got)
+		}
+		if c.Out == "" {
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("expected status %d, got %d", http.StatusNoContent, w.Code)
+			}
+		} else if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Fatalf("unexpected Content-Type %q", ct)
+		}
+	}
+}
+
+func TestContentType(t *testing.T) {
+	h := NewHandler(&Echoer{})
+
+	for i, c := range []struct {
+		ContentType string
+		Status      int
+	}{
+		{"application/json", http.StatusOK},
+		{"application/json; charset=utf-8", http.StatusOK},
+		{"Application/JSON;charset=UTF-8", http.StatusOK},
+		{"application/json-rpc", http.StatusOK},
+		{"text/plain", http.StatusUnsupportedMediaType},
+		{"application/json;;", http.StatusUnsupportedMediaType},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "Echoer.Echo",
+			"params": ["Hello world!"]
+		}`))
+		req.Header.Set("Content-Type", c.ContentType)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		if w.Code != c.Status {
+			t.Fatalf("expected status %d for %q, got %d", c.Status, c.ContentType, w.Code)
+		}
+	}
+}
+
+func TestAllowGET(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.AllowGET("Echoer.Echo")
+
+	for i, c := range []struct {
+		Query  string
+		Out    string
+		Status int
+	}{
+		{`?method=Echoer.Echo&id=1&params=%5B%22Hello%20world!%22%5D`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": "Hello world!"
+		}`, http.StatusOK},
+		{`?method=Echoer.Echo&id=%22abc%22&params=%22Hello%22`, `{
+			"jsonrpc": "2.0",
+			"id": "abc",
+			"result": "Hello"
+		}`, http.StatusOK},
+		{`?method=Echoer.Echo&id=1&params=%5B`, `{
+			"jsonrpc": "2.0",
+			"id": null,
+			"error": {
+				"code": -32700,
+				"message": "Invalid params: must be a JSON value"
+			}
+		}`, http.StatusOK},
+		{`?method=Echoer.Echo&params=%5B%22Hello%22%5D`, ``, http.StatusNoContent},
+		{`?method=Echoer.DelayEcho&id=1&params=%5B%22Hello%22,0%5D`, "Unsupported method: must be POST\n", http.StatusMethodNotAllowed},
+	} {
+		req := httptest.NewRequest("GET", "/"+c.Query, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		if w.Code != c.Status {
+			t.Fatalf("expected status %d, got %d", c.Status, w.Code)
+		}
+		if c.Status == http.StatusMethodNotAllowed {
+			if got := w.Body.String(); got != c.Out {
+				t.Fatalf("expected: %q\ngot: %q", c.Out, got)
+			}
+			continue
+		}
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.Compression = true
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write([]byte(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "Echoer.Echo",
+		"params": ["Hello world!"]
+	}`))
+	gz.Close()
+
+	req := httptest.NewRequest("POST", "/", &body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Accept-Encoding", "deflate, gzip;q=0.5")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("expected gzip Content-Encoding, got %q", got)
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := io.Copy(&out, zr); err != nil {
+		t.Fatal(err)
+	}
+	expectJSON(t, &out, `{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"result": "Hello world!"
+	}`)
+
+	// Clients that do not accept gzip get an uncompressed response.
+	req = httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "Echoer.Echo",
+		"params": ["Hello world!"]
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Encoding", "gzip;q=0")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("expected no Content-Encoding, got %q", got)
+	}
+	expectJSON(t, w.Body, `{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"result": "Hello world!"
+	}`)
+}
+
+func TestNewServerError(t *testing.T) {
+	e := NewServerError(5, "Out of stock", "widget")
+	if e.Code != -32005 || e.Message != "Out of stock" || e.Data != "widget" {
+		t.Fatalf("unexpected error: %+v", e)
+	}
+	for _, offset := range []int{-1, 100} {
+		(func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Fatalf("offset %d did not panic", offset)
+				}
+			}()
+			NewServerError(offset, "", nil)
+		})()
+	}
+}
+
+func TestErrorDataOmitted(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethod("nodata", func() error {
+		return &Error{Code: 101, Message: "no data"}
+	})
+	h.RegisterMethod("typednil", func() error {
+		var data *struct{ Reason string }
+		return &Error{Code: 102, Message: "typed nil data", Data: data}
+	})
+	h.RegisterMethod("data", func() error {
+		return &Error{Code: 103, Message: "data", Data: []int{}}
+	})
+
+	for i, c := range []struct {
+		Method string
+		Out    string
+	}{
+		{"nodata", `{"jsonrpc":"2.0","id":1,"error":{"code":101,"message":"no data"}}`},
+		{"typednil", `{"jsonrpc":"2.0","id":1,"error":{"code":102,"message":"typed nil data"}}`},
+		{"data", `{"jsonrpc":"2.0","id":1,"error":{"code":103,"message":"data","data":[]}}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "`+c.Method+`"
+		}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		if got := strings.TrimSpace(w.Body.String()); got != c.Out {
+			t.Fatalf("expected: %s\ngot: %s", c.Out, got)
+		}
+	}
+}
+
+func TestErrorMapper(t *testing.T) {
+	errNotFound := errors.New("not found")
+
+	h := NewHandler()
+	h.ErrorMapper = func(err error) *Error {
+		if errors.Is(err, errNotFound) {
+			return &Error{Code: -32004, Message: "Not found", Data: err.Error()}
+		}
+		return nil
+	}
+	h.RegisterMethod("find", func(name string) error {
+		return fmt.Errorf("find %s: %w", name, errNotFound)
+	})
+	var causes []error
+	h.Logger = func(entry LogEntry) {
+		causes = append(causes, errors.Unwrap(entry.Error))
+	}
+	h.RegisterMethod("error", func(s string) error {
+		return errors.New(s)
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "find",
+			"params": ["x"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"error": {
+				"code": -32004,
+				"message": "Not found",
+				"data": "find x: not found"
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "error",
+			"params": ["custom error"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"error": {
+				"code": -32603,
+				"message": "custom error"
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+
+	// The original errors are preserved.
+	if len(causes) != 2 || !errors.Is(causes[0], errNotFound) || causes[1] == nil || causes[1].Error() != "custom error" {
+		t.Fatalf("expected original errors to be preserved, got %v", causes)
+	}
+}
+
+func TestMaskInternalErrors(t *testing.T) {
+	var original error
+	h := NewHandler()
+	h.MaskInternalErrors = true
+	h.ResponseInterceptor = func(ctx context.Context, req Request, res *Response) error {
+		if res.Error != nil {
+			original = errors.Unwrap(res.Error)
+		}
+		return nil
+	}
+	h.RegisterMethod("error", func(s string) error {
+		return errors.New(s)
+	})
+	h.RegisterMethod("app.error", func() error {
+		return &Error{Code: 101, Message: "application error"}
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "error",
+			"params": ["/etc/secret: permission denied"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"error": {
+				"code": -32603,
+				"message": "Internal error"
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "app.error"
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"error": {
+				"code": 101,
+				"message": "application error"
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+		if i == 0 && (original == nil || original.Error() != "/etc/secret: permission denied") {
+			t.Fatalf("expected original error to be preserved, got %v", original)
+		}
+	}
+}
+
+func TestConcurrentRegister(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	body := `{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]}`
+
+	// Register, alias and unregister methods while others are being served.
+	// Run with the race detector to check for data races.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			name := fmt.Sprintf("method%d", i)
+			h.RegisterMethod(name, func() {})
+			h.Alias(name+".alias", name)
+			h.Use(func(next MethodFunc) MethodFunc { return next })
+			h.Unregister(name)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		expectJSON(t, w.Body, `{"jsonrpc": "2.0", "id": 1, "result": "Hello world!"}`)
+		h.Methods()
+	}
+	<-done
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	h := NewHandler(&Echoer{})
+	body := []byte(`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]}`)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func BenchmarkServeHTTPParallel(b *testing.B) {
+	h := NewHandler(&Echoer{})
+	body := []byte(`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]}`)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
+}
+
+func BenchmarkServeConn(b *testing.B) {
+	h := NewHandler(&Echoer{})
+	body := []byte(`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]}` + "\n")
+	stream := bytes.Repeat(body, b.N)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	h.ServeConn(context.Background(), struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(stream), ioutil.Discard})
+}
+
+func BenchmarkServeConnLarge(b *testing.B) {
+	large := strings.Repeat("x", 1<<20)
+	for _, direct := range []bool{false, true} {
+		b.Run(fmt.Sprintf("direct=%v", direct), func(b *testing.B) {
+			h := NewHandler()
+			h.DirectConnWrites = direct
+			h.RegisterMethod("large", func() string {
+				return large
+			})
+			body := []byte(`{"jsonrpc": "2.0", "id": 1, "method": "large"}` + "\n")
+			stream := bytes.Repeat(body, b.N)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			h.ServeConn(context.Background(), struct {
+				io.Reader
+				io.Writer
+			}{bytes.NewReader(stream), ioutil.Discard})
+		})
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Package jsonrpctest provides helpers for testing JSON-RPC 2.0 handlers.
+
+Call makes a request and unmarshals its result, while Expect compares the
+raw response with the JSON that is expected. Both serve the request over
+HTTP, and CallConn and ExpectConn do the same over ServeConn. For example:
+
+	func TestEcho(t *testing.T) {
+		h := jsonrpc.NewHandler(&Echoer{})
+
+		var s string
+		if err := jsonrpctest.Call(t, h, "Echoer.Echo", []string{"Hello"}, &s); err != nil {
+			t.Fatal(err)
+		}
+
+		jsonrpctest.Expect(t, h,
+			`{"jsonrpc": "2.0", "id": 1, "method": "unknown"}`,
+			`{"jsonrpc": "2.0", "id": 1, "error": {"code": -32601, "message": "No such method: unknown"}}`,
+		)
+	}
+*/
+package jsonrpctest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chowey/jsonrpc"
+)
+
+// Call calls the method with params over HTTP, and unmarshals its result into
+// result unless result is nil. Params are marshaled as JSON, and are omitted
+// if nil. If the Handler sends a JSON-RPC error, then Call returns it as a
+// *jsonrpc.Error. The test fails if the exchange itself does not follow the
+// protocol.
+func Call(t testing.TB, h http.Handler, method string, params, result interface{}) error {
+	t.Helper()
+	return decodeResult(t, serveHTTP(t, h, newRequest(t, method, params)), result)
+}
+
+// CallConn is like Call, but makes the call over ServeConn.
+func CallConn(t testing.TB, h *jsonrpc.Handler, method string, params, result interface{}) error {
+	t.Helper()
+	return decodeResult(t, serveConn(h, newRequest(t, method, params)), result)
+}
+
+// Expect sends the request over HTTP, and fails the test unless the response
+// is equivalent JSON to want. Whitespace and the order of object members do
+// not matter. An empty want expects no response, as for a notification.
+func Expect(t testing.TB, h http.Handler, request, want string) {
+	t.Helper()
+	expect(t, serveHTTP(t, h, request), want)
+}
+
+// ExpectConn is like Expect, but sends the request over ServeConn. The request
+// and want may each hold several messages, which are compared in the order
+// the responses were sent. Since calls over ServeConn run concurrently, set
+// MaxConcurrency to 1 if their order matters.
+func ExpectConn(t testing.TB, h *jsonrpc.Handler, request, want string) {
+	t.Helper()
+	expect(t, serveConn(h, request), want)
+}
+
+// newRequest returns the JSON text of a request with an ID of 1.
+func newRequest(t testing.TB, method string, params interface{}) string {
+	t.Helper()
+	req := struct {
+		Protocol string      `json:"jsonrpc"`
+		ID       int         `json:"id"`
+		Method   string      `json:"method"`
+		Params   interface{} `json:"params,omitempty"`
+	}{"2.0", 1, method, params}
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshaling params: %v", err)
+	}
+	return string(b)
+}
+
+func serveHTTP(t testing.TB, h http.Handler, request string) string {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/", strings.NewReader(request))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK && w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected HTTP status %d: %s", w.Code, w.Body)
+	}
+	return w.Body.String()
+}
+
+func serveConn(h *jsonrpc.Handler, request string) string {
+	var out bytes.Buffer
+	h.ServeConn(context.Background(), struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(request), &out})
+	return out.String()
+}
+
+// decodeResult decodes a response, and unmarshals its result into result
+// unless result is nil.
+func decodeResult(t testing.TB, response string, result interface{}) error {
+	t.Helper()
+	var res struct {
+		Result json.RawMessage `json:"result"`
+		Error  *jsonrpc.Error  `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(response), &res); err != nil {
+		t.Fatalf("parsing response: %s\nencountered error: %v", response, err)
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if result != nil {
+		if err := json.Unmarshal(res.Result, result); err != nil {
+			t.Fatalf("unmarshaling result: %s\nencountered error: %v", res.Result, err)
+		}
+	}
+	return nil
+}
+
+// expect fails the test unless got and want hold equivalent JSON values.
+func expect(t testing.TB, got, want string) {
+	t.Helper()
+	gotValues, err := values(got)
+	if err != nil {
+		t.Fatalf("parsing response: %s\nencountered error: %v", got, err)
+	}
+	wantValues, err := values(want)
+	if err != nil {
+		t.Fatalf("parsing expected: %s\nencountered error: %v", want, err)
+	}
+	if !reflect.DeepEqual(gotValues, wantValues) {
+		t.Fatalf("expected: %s\ngot: %s", want, got)
+	}
+}
+
+// values decodes every JSON value in s. Numbers are kept as json.Number, so
+// that they are compared exactly.
+func values(s string) ([]interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var vs []interface{}
+	for {
+		var v interface{}
+		if err := dec.Decode(&v); err == io.EOF {
+			return vs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+}
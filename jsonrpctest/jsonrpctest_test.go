@@ -0,0 +1,113 @@
+package jsonrpctest
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/chowey/jsonrpc"
+)
+
+type point struct {
+	X, Y int
+}
+
+func newHandler() *jsonrpc.Handler {
+	h := jsonrpc.NewHandler()
+	h.MaxConcurrency = 1
+	h.RegisterMethod("add", func(a, b point) point {
+		return point{a.X + b.X, a.Y + b.Y}
+	})
+	h.RegisterMethod("fail", func() error {
+		return errors.New("failed")
+	})
+	return h
+}
+
+// recorder is a testing.TB that records whether the test failed.
+type recorder struct {
+	testing.TB
+	failed string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.failed = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+// fails reports whether fn fails the test it is given.
+func fails(t *testing.T, fn func(t testing.TB)) bool {
+	r := &recorder{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(r)
+	}()
+	<-done
+	return r.failed != ""
+}
+
+func TestCall(t *testing.T) {
+	h := newHandler()
+	for _, call := range []func(testing.TB, string, interface{}, interface{}) error{
+		func(t testing.TB, method string, params, result interface{}) error {
+			return Call(t, h, method, params, result)
+		},
+		func(t testing.TB, method string, params, result interface{}) error {
+			return CallConn(t, h, method, params, result)
+		},
+	} {
+		var sum point
+		if err := call(t, "add", []point{{1, 2}, {3, 4}}, &sum); err != nil {
+			t.Fatal(err)
+		}
+		if sum != (point{4, 6}) {
+			t.Fatalf("unexpected sum: %+v", sum)
+		}
+
+		var rpcErr *jsonrpc.Error
+		err := call(t, "fail", nil, nil)
+		if !errors.As(err, &rpcErr) || rpcErr.Code != jsonrpc.StatusInternalError || rpcErr.Message != "failed" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = call(t, "add", nil, nil)
+		if !errors.As(err, &rpcErr) || rpcErr.Code != jsonrpc.StatusInvalidParams {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestExpect(t *testing.T) {
+	h := newHandler()
+	Expect(t, h,
+		`{"jsonrpc": "2.0", "id": 1, "method": "add", "params": [{"X": 1, "Y": 2}, {"X": 3, "Y": 4}]}`,
+		`{"id": 1, "jsonrpc": "2.0", "result": {"Y": 6, "X": 4}}`,
+	)
+	Expect(t, h, `{"jsonrpc": "2.0", "method": "fail"}`, ``)
+	ExpectConn(t, h,
+		`{"jsonrpc": "2.0", "id": 1, "method": "add", "params": [{"X": 1, "Y": 2}, {"X": 3, "Y": 4}]}
+		{"jsonrpc": "2.0", "method": "fail"}
+		{"jsonrpc": "2.0", "id": 2, "method": "fail"}`,
+		`{"jsonrpc": "2.0", "id": 1, "result": {"X": 4, "Y": 6}}
+		{"jsonrpc": "2.0", "id": 2, "error": {"code": -32603, "message": "failed"}}`,
+	)
+
+	if !fails(t, func(t testing.TB) {
+		Expect(t, h, `{"jsonrpc": "2.0", "id": 1, "method": "fail"}`, `{"jsonrpc": "2.0", "id": 1, "result": null}`)
+	}) {
+		t.Fatal("expected a mismatched response to fail the test")
+	}
+	if !fails(t, func(t testing.TB) {
+		ExpectConn(t, h, `{"jsonrpc": "2.0", "id": 1, "method": "fail"}`, ``)
+	}) {
+		t.Fatal("expected an unexpected response to fail the test")
+	}
+	if !fails(t, func(t testing.TB) {
+		Call(t, h, "add", make(chan int), nil)
+	}) {
+		t.Fatal("expected unmarshalable params to fail the test")
+	}
+}
@@ -0,0 +1,38 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// LogEntry describes a request that has been served, for use with
+// Handler.Logger.
+type LogEntry struct {
+	Method       string          // The requested method, if known.
+	ID           json.RawMessage // The request ID, or nil for a notification.
+	Notification bool            // Whether the request was a notification.
+	Duration     time.Duration   // How long the method took to run.
+	Deprecated   bool            // Whether the method is deprecated.
+	Error        *Error          // The error sent to the client, if any.
+	SendError    error           // The error sending the response, if any.
+}
+
+// log reports a served request to the Logger.
+func (h *Handler) log(req *request, sendErr error) {
+	if h.Logger == nil {
+		return
+	}
+	var id json.RawMessage
+	if req.res.ID != nil {
+		id = json.RawMessage(req.res.ID)
+	}
+	h.Logger(LogEntry{
+		Method:       req.Method,
+		ID:           id,
+		Notification: req.res.ID == nil,
+		Duration:     req.duration,
+		Deprecated:   req.deprecation != nil,
+		Error:        req.res.Error,
+		SendError:    sendErr,
+	})
+}
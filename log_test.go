@@ -0,0 +1,118 @@
+package jsonrpc
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+	var mu sync.Mutex
+	var entries []LogEntry
+	h := NewHandler(&Echoer{})
+	h.Logger = func(entry LogEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		entries = append(entries, entry)
+	}
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "Echoer.DelayEcho",
+		"params": ["Hello world!", 10]
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 log entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Method != "Echoer.DelayEcho" || string(e.ID) != "1" || e.Notification || e.Error != nil || e.SendError != nil {
+		t.Fatalf("unexpected log entry: %+v", e)
+	}
+	if e.Duration < 10e6 {
+		t.Fatalf("expected duration of at least 10ms, got %v", e.Duration)
+	}
+
+	// Over a stream, notifications, errors and write failures are logged.
+	entries = nil
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte(`{"jsonrpc": "2.0", "method": "Echoer.Echo", "params": ["Notification"]}`))
+		pw.Write([]byte(`{"jsonrpc": "2.0", "id": 2, "method": "unknown"}`))
+		pw.Close()
+	}()
+	h.ServeConn(context.Background(), struct {
+		io.Reader
+		io.Writer
+	}{pr, failingWriter{}})
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 log entries, got %d", len(entries))
+	}
+	for _, e := range entries {
+		switch e.Method {
+		case "Echoer.Echo":
+			if !e.Notification || e.ID != nil || e.Error != nil || e.SendError != nil {
+				t.Fatalf("unexpected log entry: %+v", e)
+			}
+		case "unknown":
+			if e.Notification || e.Error == nil || e.Error.Code != StatusMethodNotFound || e.SendError != io.ErrClosedPipe {
+				t.Fatalf("unexpected log entry: %+v", e)
+			}
+		default:
+			t.Fatalf("unexpected log entry: %+v", e)
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestMetrics(t *testing.T) {
+	var mu sync.Mutex
+	started := make(map[string]int)
+	ended := make(map[string]int)
+	errored := make(map[string]int)
+
+	h := NewHandler(&Echoer{})
+	h.OnCallStart = func(method string) {
+		mu.Lock()
+		defer mu.Unlock()
+		started[method]++
+	}
+	h.OnCallEnd = func(method string, d time.Duration, err *Error) {
+		mu.Lock()
+		defer mu.Unlock()
+		ended[method]++
+		if err != nil {
+			errored[method]++
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`[
+		{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["a"]},
+		{"jsonrpc": "2.0", "method": "Echoer.Echo", "params": ["b"]},
+		{"jsonrpc": "2.0", "id": 2, "method": "unknown"}
+	]`))
+	req.Header.Set("Content-Type", "application/json")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if started["Echoer.Echo"] != 2 || ended["Echoer.Echo"] != 2 || errored["Echoer.Echo"] != 0 {
+		t.Fatalf("unexpected metrics for Echoer.Echo: started %d, ended %d, errored %d",
+			started["Echoer.Echo"], ended["Echoer.Echo"], errored["Echoer.Echo"])
+	}
+	if started["unknown"] != 1 || ended["unknown"] != 1 || errored["unknown"] != 1 {
+		t.Fatalf("unexpected metrics for unknown: started %d, ended %d, errored %d",
+			started["unknown"], ended["unknown"], errored["unknown"])
+	}
+}
@@ -0,0 +1,115 @@
+package jsonrpc
+
+import (
+	"fmt"
+
+	"golang.org/x/time/rate"
+)
+
+// Mount registers every method of sub under the same name prefixed with
+// prefix and a dot, so that sub's "echo" becomes "prefix.echo". This lets
+// independently developed Handlers be assembled into one.
+//
+// What sub configures for its methods is carried over: aliases, AllowGET,
+// RateLimit, RequireScopes, Deprecate and Idempotent. Everything else, such as
+// middleware and the Handler's fields, is taken from h. Methods registered
+// with sub later are not mounted.
+//
+// Mount returns an error, and mounts nothing, if any prefixed name is already
+// registered with h or is not a valid name for h.
+func (h *Handler) Mount(prefix string, sub *Handler) error {
+	name := func(s string) string { return prefix + "." + s }
+
+	// Copy what sub has before locking h, in case sub is h.
+	sub.mu.RLock()
+	methods := make(map[string]*method, len(sub.registry))
+	for s, m := range sub.registry {
+		methods[name(s)] = m
+	}
+	aliases := make(map[string]string)
+	for s, target := range sub.aliases {
+		aliases[name(s)] = name(target)
+	}
+	get := make(map[string]bool)
+	for s := range sub.get {
+		get[name(s)] = true
+	}
+	idempotent := make(map[string]bool)
+	for s := range sub.idempotent {
+		idempotent[name(s)] = true
+	}
+	limits := make(map[string]*rate.Limiter)
+	for s, limiter := range sub.limits {
+		limits[name(s)] = limiter
+	}
+	scopes := make(map[string][]string)
+	for s, required := range sub.scopes {
+		scopes[name(s)] = required
+	}
+	deprecated := make(map[string]string)
+	for s, replacement := range sub.deprecated {
+		if _, ok := sub.registry[replacement]; ok {
+			replacement = name(replacement)
+		}
+		deprecated[name(s)] = replacement
+	}
+	sub.mu.RUnlock()
+
+	for s := range methods {
+		if err := h.checkName(s); err != nil {
+			return err
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range methods {
+		if _, ok := h.registry[s]; ok {
+			return fmt.Errorf("%s: method already registered", s)
+		}
+	}
+	if h.registry == nil {
+		h.registry = make(map[string]*method)
+	}
+	for s, m := range methods {
+		h.registry[s] = m
+	}
+	h.updateStreaming()
+	if len(aliases) > 0 && h.aliases == nil {
+		h.aliases = make(map[string]string)
+	}
+	for s, target := range aliases {
+		h.aliases[s] = target
+	}
+	if len(get) > 0 && h.get == nil {
+		h.get = make(map[string]bool)
+	}
+	for s := range get {
+		h.get[s] = true
+	}
+	if len(idempotent) > 0 && h.idempotent == nil {
+		h.idempotent = make(map[string]bool)
+	}
+	for s := range idempotent {
+		h.idempotent[s] = true
+	}
+	if len(limits) > 0 && h.limits == nil {
+		h.limits = make(map[string]*rate.Limiter)
+	}
+	for s, limiter := range limits {
+		h.limits[s] = limiter
+	}
+	if len(scopes) > 0 && h.scopes == nil {
+		h.scopes = make(map[string][]string)
+	}
+	for s, required := range scopes {
+		h.scopes[s] = required
+	}
+	if len(deprecated) > 0 && h.deprecated == nil {
+		h.deprecated = make(map[string]string)
+	}
+	for s, replacement := range deprecated {
+		h.deprecated[s] = replacement
+	}
+	return nil
+}
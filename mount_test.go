@@ -0,0 +1,80 @@
+package jsonrpc
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMount(t *testing.T) {
+	sub := NewHandler(&Echoer{})
+	if err := sub.Alias("echo", "Echoer.Echo"); err != nil {
+		t.Fatal(err)
+	}
+	sub.Deprecate("echo", "Echoer.Echo")
+
+	h := NewHandler()
+	h.RegisterMethod("version", func() string { return "1.0" })
+	if err := h.Mount("v1", sub); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Mount("v1", sub); err == nil {
+		t.Fatal("expected error mounting over existing methods")
+	}
+
+	expected := []string{"v1.Echoer.DelayEcho", "v1.Echoer.Echo", "v1.echo", "version"}
+	if names := h.Methods(); !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected: %v\ngot: %v", expected, names)
+	}
+	info, _ := h.Method("v1.echo")
+	if info.AliasOf != "v1.Echoer.Echo" || !info.Deprecated {
+		t.Fatalf("expected a deprecated alias of v1.Echoer.Echo, got %+v", info)
+	}
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "v1.Echoer.Echo",
+			"params": ["Hello world!"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": "Hello world!"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "Echoer.Echo",
+			"params": ["Hello world!"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"error": {
+				"code": -32601,
+				"message": "No such method: Echoer.Echo"
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc": "2.0", "id": 3, "method": "v1.echo", "params": ["Hello world!"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if warning := w.Header().Get("Warning"); !strings.Contains(warning, "use v1.Echoer.Echo instead") {
+		t.Fatalf("unexpected Warning header: %q", warning)
+	}
+}
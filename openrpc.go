@@ -0,0 +1,320 @@
+package jsonrpc
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OpenRPCVersion is the version of the OpenRPC specification implemented by
+// Handler.OpenRPC.
+const OpenRPCVersion = "1.2.6"
+
+// DiscoverMethod is the method name registered by EnableDiscovery, which is
+// the name OpenRPC clients use to discover a service.
+const DiscoverMethod = "rpc.discover"
+
+// EnableDiscovery registers the OpenRPC document under DiscoverMethod. The
+// name is reserved, but is registered even if AllowReservedNames is not set.
+func (h *Handler) EnableDiscovery() {
+	m, err := newMethod(DiscoverMethod, h.OpenRPC)
+	if err != nil {
+		panic(err)
+	}
+	if err := h.add(DiscoverMethod, m); err != nil {
+		panic(err)
+	}
+}
+
+// OpenRPC generates an OpenRPC service document describing every registered
+// method. The schema of each param and result is derived from its Go type.
+// Struct fields are named the same way encoding/json names them.
+//
+// Params are named by RegisterMethodNamed, or else by position as "arg0",
+// "arg1" and so on. A variadic param is described by the schema of a single
+// element, and is not required. A raw method is described as taking a single
+// param, "params", which may be any value.
+//
+// To let clients discover the service using the OpenRPC convention, call
+// EnableDiscovery.
+func (h *Handler) OpenRPC() (json.RawMessage, error) {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.registry))
+	methods := make(map[string]*method, len(h.registry))
+	deprecated := make(map[string]bool)
+	aliases := make(map[string]string)
+	for name, m := range h.registry {
+		names = append(names, name)
+		methods[name] = m
+		if _, ok := h.deprecated[name]; ok {
+			deprecated[name] = true
+		} else if _, ok := h.deprecated[h.resolve(name)]; ok {
+			deprecated[name] = true
+		}
+		if target, ok := h.aliases[name]; ok {
+			aliases[name] = target
+		}
+	}
+	h.mu.RUnlock()
+	sort.Strings(names)
+
+	g := &schemaGenerator{
+		names:   make(map[reflect.Type]string),
+		schemas: make(map[string]interface{}),
+	}
+	docs := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		doc := g.method(name, methods[name])
+		if deprecated[name] {
+			doc["deprecated"] = true
+		}
+		if target, ok := aliases[name]; ok {
+			// OpenRPC has no aliases, so this is a specification extension.
+			doc["x-alias-of"] = target
+		}
+		docs = append(docs, doc)
+	}
+
+	doc := map[string]interface{}{
+		"openrpc": OpenRPCVersion,
+		"info": map[string]interface{}{
+			"title":   "JSON-RPC",
+			"version": "0.0.0",
+		},
+		"methods": docs,
+	}
+	if len(g.schemas) > 0 {
+		doc["components"] = map[string]interface{}{
+			"schemas": g.schemas,
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// schemaGenerator generates JSON schemas from Go types. Named struct types are
+// placed in the components section and referenced, which allows recursive
+// types.
+type schemaGenerator struct {
+	names   map[reflect.Type]string
+	schemas map[string]interface{}
+}
+
+func (g *schemaGenerator) method(name string, m *method) map[string]interface{} {
+	params := make([]interface{}, 0, len(m.ins)+1)
+	paramName := func(i int) string {
+		if m.names != nil {
+			return m.names[i]
+		}
+		return fmt.Sprintf("arg%d", i)
+	}
+	if m.raw != nil {
+		// The params are passed along as sent, so they may be any value,
+		// by position or by name.
+		params = append(params, map[string]interface{}{
+			"name":     "params",
+			"required": false,
+			"schema":   map[string]interface{}{},
+		})
+	} else {
+		for i, t := range m.ins {
+			params = append(params, map[string]interface{}{
+				"name":     paramName(i),
+				"required": i < m.nargs-m.optional,
+				"schema":   g.schema(t),
+			})
+		}
+		if m.variadic != nil {
+			params = append(params, map[string]interface{}{
+				"name":     paramName(len(m.ins)),
+				"required": false,
+				"schema":   g.schema(m.variadic),
+			})
+		}
+	}
+	for i, desc := range m.meta.Params {
+		if desc != "" {
+			params[i].(map[string]interface{})["description"] = desc
+		}
+	}
+
+	structure := "by-position"
+	if m.names != nil || m.raw != nil {
+		structure = "either"
+	}
+
+	// Results follow the same rules as the method call.
+	t := m.Type()
+	var result interface{}
+	switch m.nresults {
+	case 0:
+		result = map[string]interface{}{"type": "null"}
+	case 1:
+		result = g.schema(t.Out(0))
+	default:
+		items := make([]interface{}, m.nresults)
+		for i := range items {
+			items[i] = g.schema(t.Out(i))
+		}
+		result = map[string]interface{}{
+			"type":     "array",
+			"items":    items,
+			"minItems": m.nresults,
+			"maxItems": m.nresults,
+		}
+	}
+
+	doc := map[string]interface{}{
+		"name":           name,
+		"params":         params,
+		"paramStructure": structure,
+		"result": map[string]interface{}{
+			"name":   "result",
+			"schema": result,
+		},
+	}
+	if m.meta.Summary != "" {
+		doc["summary"] = m.meta.Summary
+	}
+	if m.meta.Description != "" {
+		doc["description"] = m.meta.Description
+	}
+	if m.meta.Deprecated {
+		doc["deprecated"] = true
+	}
+	return doc
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	rawMessageType      = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	if t == readerType || t == decoderType {
+		// The param is read as raw JSON, so it may be any value.
+		return map[string]interface{}{}
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Types with custom marshaling may have any shape.
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	case t.Implements(jsonMarshalerType), reflect.PtrTo(t).Implements(jsonUnmarshalerType):
+		return map[string]interface{}{}
+	case t.Implements(textMarshalerType):
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Byte slices are encoded as base64 strings.
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Array:
+		return map[string]interface{}{
+			"type":     "array",
+			"items":    g.schema(t.Elem()),
+			"minItems": t.Len(),
+			"maxItems": t.Len(),
+		}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + g.ref(t)}
+	}
+	// Interfaces and anything else may be any value.
+	return map[string]interface{}{}
+}
+
+// ref returns the component name of a named struct type, generating its schema
+// on first use.
+func (g *schemaGenerator) ref(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	for i := 2; g.schemas[name] != nil; i++ {
+		name = fmt.Sprintf("%s%d", t.Name(), i)
+	}
+	g.names[t] = name
+	// Reserve the name before generating the schema, in case it is recursive.
+	g.schemas[name] = map[string]interface{}{}
+	g.schemas[name] = g.structSchema(t)
+	return name
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	g.fields(t, props)
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// fields adds the properties of a struct's fields, following the naming rules
+// of encoding/json. Embedded structs without a JSON name are flattened.
+func (g *schemaGenerator) fields(t reflect.Type, props map[string]interface{}) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		// Field must be exported.
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+	}
+
+	// Shallower fields take precedence over embedded fields.
+	for _, ft := range embedded {
+		inner := make(map[string]interface{})
+		g.fields(ft, inner)
+		for name, schema := range inner {
+			if _, ok := props[name]; !ok {
+				props[name] = schema
+			}
+		}
+	}
+}
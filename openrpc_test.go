@@ -0,0 +1,163 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestOpenRPC(t *testing.T) {
+	type node struct {
+		Value    string  `json:"value"`
+		Children []*node `json:"children,omitempty"`
+		Secret   string  `json:"-"`
+	}
+
+	h := NewHandler(&Echoer{})
+	h.RegisterMethod("tree", func(ctx context.Context, n node) (int, error) {
+		return len(n.Children), nil
+	})
+	h.RegisterMethodNamed("dial", func(host string, port int) (string, bool) {
+		return host, port > 0
+	}, "host", "port")
+	h.RegisterMethod("proxy", func(ctx context.Context, params json.RawMessage) (json.RawMessage, error) {
+		return params, nil
+	})
+	h.EnableDiscovery()
+
+	doc, err := h.OpenRPC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectEquivalentJSON(t, doc, `{
+		"openrpc": "1.2.6",
+		"info": {"title": "JSON-RPC", "version": "0.0.0"},
+		"methods": [
+			{
+				"name": "Echoer.DelayEcho",
+				"params": [
+					{"name": "arg0", "required": true, "schema": {"type": "string"}},
+					{"name": "arg1", "required": true, "schema": {"type": "integer"}}
+				],
+				"paramStructure": "by-position",
+				"result": {"name": "result", "schema": {"type": "string"}}
+			},
+			{
+				"name": "Echoer.Echo",
+				"params": [
+					{"name": "arg0", "required": true, "schema": {"type": "string"}}
+				],
+				"paramStructure": "by-position",
+				"result": {"name": "result", "schema": {"type": "string"}}
+			},
+			{
+				"name": "dial",
+				"params": [
+					{"name": "host", "required": true, "schema": {"type": "string"}},
+					{"name": "port", "required": true, "schema": {"type": "integer"}}
+				],
+				"paramStructure": "either",
+				"result": {"name": "result", "schema": {
+					"type": "array",
+					"items": [{"type": "string"}, {"type": "boolean"}],
+					"minItems": 2,
+					"maxItems": 2
+				}}
+			},
+			{
+				"name": "proxy",
+				"params": [
+					{"name": "params", "required": false, "schema": {}}
+				],
+				"paramStructure": "either",
+				"result": {"name": "result", "schema": {}}
+			},
+			{
+				"name": "rpc.discover",
+				"params": [],
+				"paramStructure": "by-position",
+				"result": {"name": "result", "schema": {}}
+			},
+			{
+				"name": "tree",
+				"params": [
+					{"name": "arg0", "required": true, "schema": {"$ref": "#/components/schemas/node"}}
+				],
+				"paramStructure": "by-position",
+				"result": {"name": "result", "schema": {"type": "integer"}}
+			}
+		],
+		"components": {
+			"schemas": {
+				"node": {
+					"type": "object",
+					"properties": {
+						"value": {"type": "string"},
+						"children": {"type": "array", "items": {"$ref": "#/components/schemas/node"}}
+					}
+				}
+			}
+		}
+	}`)
+}
+
+// expectEquivalentJSON is like expectJSON but ignores the order of object keys.
+func TestOpenRPCMeta(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethodWithMeta("greet", func(ctx context.Context, name string, excited bool) string {
+		return "Hello " + name
+	}, MethodMeta{
+		Summary:     "Greets someone.",
+		Description: "Returns a greeting for the given name.",
+		Params:      []string{"Who to greet."},
+		Deprecated:  true,
+	})
+
+	if err := h.TryRegisterMethodWithMeta("bad", func(a string) {}, MethodMeta{Params: []string{"a", "b"}}); err == nil {
+		t.Fatal("expected error registering with too many param descriptions")
+	}
+
+	doc, err := h.OpenRPC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEquivalentJSON(t, doc, `{
+		"openrpc": "1.2.6",
+		"info": {"title": "JSON-RPC", "version": "0.0.0"},
+		"methods": [
+			{
+				"name": "greet",
+				"summary": "Greets someone.",
+				"description": "Returns a greeting for the given name.",
+				"deprecated": true,
+				"params": [
+					{"name": "arg0", "description": "Who to greet.", "required": true, "schema": {"type": "string"}},
+					{"name": "arg1", "required": true, "schema": {"type": "boolean"}}
+				],
+				"paramStructure": "by-position",
+				"result": {"name": "result", "schema": {"type": "string"}}
+			}
+		]
+	}`)
+
+	infos := h.listMethods()
+	if len(infos) != 1 || infos[0].Summary != "Greets someone." || !infos[0].Deprecated {
+		t.Fatalf("unexpected method info: %+v", infos)
+	}
+}
+
+func expectEquivalentJSON(t *testing.T, in []byte, expected string) {
+	var want, got interface{}
+	if err := json.Unmarshal([]byte(expected), &want); err != nil {
+		t.Fatalf("parsing expected: %s\nencountered error: %v", expected, err)
+	}
+	if err := json.Unmarshal(in, &got); err != nil {
+		t.Fatalf("parsing response: %s\nencountered error: %v", in, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		wantJSON, _ := json.Marshal(want)
+		t.Fatalf("expected: %s\ngot: %s", wantJSON, in)
+	}
+}
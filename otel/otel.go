@@ -0,0 +1,85 @@
+/*
+Package otel instruments a jsonrpc.Handler with OpenTelemetry tracing.
+
+A span is started around every method call, named after the method, and
+notifications are marked with the "rpc.jsonrpc.notification" attribute. Over
+HTTP, incoming trace context is extracted from the request headers. For
+example:
+
+	h := jsonrpc.NewHandler(&Echo{})
+	otel.Instrument(h, tp)
+	http.ListenAndServe(":8080", h)
+*/
+package otel
+
+import (
+	"context"
+	"errors"
+
+	"github.com/chowey/jsonrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer used for spans.
+const InstrumentationName = "github.com/chowey/jsonrpc/otel"
+
+// Instrument adds the Middleware to h. It should be called before any other
+// middleware is added, so that the span covers all of it.
+func Instrument(h *jsonrpc.Handler, tp trace.TracerProvider) {
+	h.Use(Middleware(tp))
+}
+
+// Middleware returns a jsonrpc.Middleware that traces every method call with a
+// tracer from tp. If tp is nil, the global TracerProvider is used, which does
+// nothing unless one has been set.
+//
+// Incoming trace context is extracted from HTTP request headers using the
+// global propagator.
+func Middleware(tp trace.TracerProvider) jsonrpc.Middleware {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	tracer := tp.Tracer(InstrumentationName)
+
+	return func(next jsonrpc.MethodFunc) jsonrpc.MethodFunc {
+		return func(ctx context.Context, req jsonrpc.Request) (interface{}, error) {
+			if r, ok := jsonrpc.HTTPRequest(ctx); ok {
+				ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+			}
+
+			attrs := []attribute.KeyValue{
+				attribute.String("rpc.system", "jsonrpc"),
+				attribute.String("rpc.method", req.Method),
+				attribute.String("rpc.jsonrpc.version", "2.0"),
+			}
+			if id, ok := jsonrpc.RequestID(ctx); ok {
+				attrs = append(attrs, attribute.String("rpc.jsonrpc.request_id", string(id)))
+			} else if jsonrpc.IsNotification(ctx) {
+				attrs = append(attrs, attribute.Bool("rpc.jsonrpc.notification", true))
+			}
+			ctx, span := tracer.Start(ctx, req.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(attrs...),
+			)
+			defer span.End()
+
+			result, err := next(ctx, req)
+			if err != nil {
+				var e *jsonrpc.Error
+				if errors.As(err, &e) {
+					span.SetAttributes(
+						attribute.Int("rpc.jsonrpc.error_code", e.Code),
+						attribute.String("rpc.jsonrpc.error_message", e.Message),
+					)
+				}
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return result, err
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chowey/jsonrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestMiddleware(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+
+	h := jsonrpc.NewHandler()
+	Instrument(h, tp)
+	h.RegisterMethod("echo", func(s string) string {
+		return s
+	})
+	h.RegisterMethod("fail", func() error {
+		return &jsonrpc.Error{Code: 101, Message: "failed"}
+	})
+
+	for _, in := range []string{
+		`{"jsonrpc": "2.0", "id": 1, "method": "echo", "params": ["Hello world!"]}`,
+		`{"jsonrpc": "2.0", "id": "abc", "method": "fail"}`,
+		`{"jsonrpc": "2.0", "method": "echo", "params": ["Hello world!"]}`,
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(in))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	spans := sr.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	for _, span := range spans {
+		if got := span.Parent().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Fatalf("expected span to continue the incoming trace, got trace %s", got)
+		}
+	}
+
+	if spans[0].Name() != "echo" || spans[0].Status().Code != codes.Unset {
+		t.Fatalf("unexpected span: %s %v", spans[0].Name(), spans[0].Status())
+	}
+	expectAttribute(t, spans[0].Attributes(), attribute.String("rpc.jsonrpc.request_id", "1"))
+
+	if spans[1].Name() != "fail" || spans[1].Status().Code != codes.Error {
+		t.Fatalf("unexpected span: %s %v", spans[1].Name(), spans[1].Status())
+	}
+	expectAttribute(t, spans[1].Attributes(), attribute.String("rpc.jsonrpc.request_id", `"abc"`))
+	expectAttribute(t, spans[1].Attributes(), attribute.Int("rpc.jsonrpc.error_code", 101))
+
+	expectAttribute(t, spans[2].Attributes(), attribute.Bool("rpc.jsonrpc.notification", true))
+}
+
+func TestMiddlewareNoProvider(t *testing.T) {
+	h := jsonrpc.NewHandler()
+	h.Use(Middleware(nil))
+	h.RegisterMethod("fail", func(ctx context.Context) error {
+		return errors.New("failed")
+	})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "fail"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"message":"failed"`) {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+}
+
+func expectAttribute(t *testing.T, attrs []attribute.KeyValue, expected attribute.KeyValue) {
+	for _, attr := range attrs {
+		if attr.Key == expected.Key {
+			if attr.Value != expected.Value {
+				t.Fatalf("expected %s = %s, got %s", expected.Key, expected.Value.Emit(), attr.Value.Emit())
+			}
+			return
+		}
+	}
+	t.Fatalf("expected attribute %s", expected.Key)
+}
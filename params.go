@@ -0,0 +1,117 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// InvalidParamData is the Data of the error sent when a param cannot be
+// unmarshaled into its argument.
+type InvalidParamData struct {
+	Param int             `json:"param"`          // The position of the param, counting from 0.
+	Name  string          `json:"name,omitempty"` // The name of the param, if the method has named params.
+	Value json.RawMessage `json:"value"`          // The param exactly as it was sent.
+}
+
+// invalidParam returns the Data of the error for the param at position i.
+func (m *method) invalidParam(i int, value json.RawMessage) InvalidParamData {
+	data := InvalidParamData{Param: i, Value: value}
+	if i < len(m.names) {
+		data.Name = m.names[i]
+	} else if len(m.names) > 0 {
+		// Params past the named ones belong to the variadic parameter.
+		data.Name = m.names[len(m.names)-1]
+	}
+	return data
+}
+
+// checkParams validates the params according to the Handler's options, before
+// they are unmarshaled.
+func (h *Handler) checkParams(name string, params json.RawMessage) *Error {
+	if h.StrictParams {
+		switch paramsKind(params) {
+		case 0, 'n', '[', '{':
+		default:
+			return &Error{
+				Code:    StatusInvalidParams,
+				Message: fmt.Sprintf("%s: params must be array, object, or omitted", name),
+			}
+		}
+	}
+	if h.MaxParamsDepth > 0 && exceedsDepth(params, h.MaxParamsDepth) {
+		return &Error{
+			Code:    StatusInvalidRequest,
+			Message: fmt.Sprintf("Invalid request: params nested deeper than %d", h.MaxParamsDepth),
+		}
+	}
+	if h.RejectDuplicateKeys {
+		dec := json.NewDecoder(bytes.NewReader(params))
+		if key, err := duplicateKey(dec); err == nil && key != "" {
+			return &Error{
+				Code:    StatusInvalidParams,
+				Message: fmt.Sprintf("Invalid params: duplicate key %q", key),
+			}
+		}
+	}
+	return nil
+}
+
+// exceedsDepth reports whether arrays and objects are nested deeper than max
+// within the params. It stops scanning as soon as they are.
+func exceedsDepth(params json.RawMessage, max int) bool {
+	dec := json.NewDecoder(bytes.NewReader(params))
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return false
+		}
+		switch tok {
+		case json.Delim('['), json.Delim('{'):
+			if depth++; depth > max {
+				return true
+			}
+		case json.Delim(']'), json.Delim('}'):
+			depth--
+		}
+	}
+}
+
+// duplicateKey scans the next JSON value and returns the first object key that
+// appears more than once within the same object, at any depth.
+func duplicateKey(dec *json.Decoder) (string, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	switch tok {
+	case json.Delim('{'):
+		seen := make(map[string]bool)
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return "", err
+			}
+			key, _ := tok.(string)
+			if seen[key] {
+				return key, nil
+			}
+			seen[key] = true
+			if key, err := duplicateKey(dec); key != "" || err != nil {
+				return key, err
+			}
+		}
+	case json.Delim('['):
+		for dec.More() {
+			if key, err := duplicateKey(dec); key != "" || err != nil {
+				return key, err
+			}
+		}
+	default:
+		return "", nil
+	}
+	// Consume the closing delimiter.
+	_, err = dec.Token()
+	return "", err
+}
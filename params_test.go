@@ -0,0 +1,708 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRejectDuplicateKeys(t *testing.T) {
+	type transfer struct {
+		Amount int `json:"amount"`
+	}
+
+	h := NewHandler()
+	h.RejectDuplicateKeys = true
+	h.RegisterMethod("transfer", func(t transfer) int {
+		return t.Amount
+	})
+	h.RegisterMethod("transfers", func(t ...transfer) int {
+		return len(t)
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "transfer",
+			"params": {"amount": 1}
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": 1
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "transfer",
+			"params": {"amount": 1, "amount": 1000}
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"error": {
+				"code": -32602,
+				"message": "Invalid params: duplicate key \"amount\""
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"method": "transfers",
+			"params": [{"amount": 1}, {"amount": 1, "nested": {"a": 1, "a": 2}}]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"error": {
+				"code": -32602,
+				"message": "Invalid params: duplicate key \"a\""
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 4,
+			"method": "transfers",
+			"params": [{"amount": 1}, {"amount": 1}]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 4,
+			"result": 2
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestDisallowUnknownParams(t *testing.T) {
+	type point struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+
+	h := NewHandler()
+	h.DisallowUnknownParams = true
+	h.RegisterMethod("sum", func(scale int, p ...point) int {
+		var sum int
+		for _, p := range p {
+			sum += scale * (p.X + p.Y)
+		}
+		return sum
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "sum",
+			"params": [2, {"x": 1, "y": 2}, {"x": 3}]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": 12
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "sum",
+			"params": [2, {"x": 1, "y": 2}, {"x": 3, "z": 4}]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"error": {
+				"code": -32602,
+				"message": "sum: json: unknown field \"z\"",
+				"data": {"param": 2, "value": {"x": 3, "z": 4}}
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestStrictParams(t *testing.T) {
+	h := NewHandler()
+	h.StrictParams = true
+	h.RegisterMethod("echo", func(s string) string {
+		return s
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "echo",
+			"params": ["Hello world!"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": "Hello world!"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "echo",
+			"params": "Hello world!"
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"error": {
+				"code": -32602,
+				"message": "echo: params must be array, object, or omitted"
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestCoerceStringNumbers(t *testing.T) {
+	h := NewHandler()
+	h.CoerceStringNumbers = true
+	h.RegisterMethod("add", func(a int, b *float64, negate bool) float64 {
+		sum := float64(a) + *b
+		if negate {
+			return -sum
+		}
+		return sum
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "add",
+			"params": ["1", "2.5", "true"]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": -3.5
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "add",
+			"params": [1, 2.5, false]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"result": 3.5
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"method": "add",
+			"params": ["one", 2, false]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"error": {
+				"code": -32602,
+				"message": "add: json: cannot unmarshal string into Go value of type int",
+				"data": {"param": 0, "value": "one"}
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestVariadicInterface(t *testing.T) {
+	describe := func(args ...interface{}) string {
+		return fmt.Sprintf("%T %v", args, args)
+	}
+
+	// Prepare test cases.
+	type compare struct {
+		UseNumber bool
+		In        string
+		Out       string
+	}
+	for i, c := range []compare{
+		{false, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "describe",
+			"params": [1, "two", true, null, [3], {"four": 4}]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": "[]interface {} [1 two true \u003cnil\u003e [3] map[four:4]]"
+		}`},
+		{false, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "describe",
+			"params": [9007199254740993]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"result": "[]interface {} [9.007199254740992e+15]"
+		}`},
+		{true, `{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"method": "describe",
+			"params": [9007199254740993, "two", {"four": 4}]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"result": "[]interface {} [9007199254740993 two map[four:4]]"
+		}`},
+		{true, `{
+			"jsonrpc": "2.0",
+			"id": 4,
+			"method": "describe"
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 4,
+			"result": "[]interface {} []"
+		}`},
+	} {
+		h := NewHandler()
+		h.UseNumber = c.UseNumber
+		h.RegisterMethod("describe", describe)
+
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+
+	t.Log("Running number test: json.Number elements")
+	h := NewHandler()
+	h.UseNumber = true
+	h.RegisterMethod("types", func(args ...interface{}) string {
+		return fmt.Sprintf("%T", args[0])
+	})
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "types", "params": [1]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	expectJSON(t, w.Body, `{"jsonrpc": "2.0", "id": 1, "result": "json.Number"}`)
+}
+
+func TestMaxParamsDepth(t *testing.T) {
+	h := NewHandler()
+	h.MaxParamsDepth = 3
+	h.RegisterMethod("depth", func(v interface{}) int {
+		depth := 0
+		for {
+			a, ok := v.([]interface{})
+			if !ok || len(a) == 0 {
+				return depth
+			}
+			v = a[0]
+			depth++
+		}
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		Params string
+		Out    string
+	}
+	for i, c := range []compare{
+		{`[[[]]]`, `{"jsonrpc": "2.0", "id": 1, "result": 1}`},
+		{`[[[[]]]]`, `{"jsonrpc": "2.0", "id": 1, "error": {"code": -32600, "message": "Invalid request: params nested deeper than 3"}}`},
+		{`[{"a": [{}]}]`, `{"jsonrpc": "2.0", "id": 1, "error": {"code": -32600, "message": "Invalid request: params nested deeper than 3"}}`},
+		{`{"a": "[[[[[["}`, `{"jsonrpc": "2.0", "id": 1, "result": 0}`},
+		{strings.Repeat("[", 5000) + strings.Repeat("]", 5000), `{"jsonrpc": "2.0", "id": 1, "error": {"code": -32600, "message": "Invalid request: params nested deeper than 3"}}`},
+	} {
+		in := `{"jsonrpc": "2.0", "id": 1, "method": "depth", "params": ` + c.Params + `}`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(in))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestPointerParam(t *testing.T) {
+	type options struct {
+		Name string `json:"name"`
+	}
+	var previous *options
+	h := NewHandler()
+	h.RegisterMethod("options", func(o *options) (string, error) {
+		if o == nil {
+			return "nil", nil
+		}
+		if o == previous {
+			return "", errors.New("options reused between calls")
+		}
+		previous = o
+		return "name=" + o.Name, nil
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		Params string
+		Out    string
+	}
+	for i, c := range []compare{
+		{`, "params": [null]`, `{"jsonrpc": "2.0", "id": 1, "result": "nil"}`},
+		{`, "params": {"name": "a"}`, `{"jsonrpc": "2.0", "id": 1, "result": "name=a"}`},
+		{`, "params": [{"name": "b"}]`, `{"jsonrpc": "2.0", "id": 1, "result": "name=b"}`},
+		{`, "params": {}`, `{"jsonrpc": "2.0", "id": 1, "result": "name="}`},
+		{`, "params": [{}]`, `{"jsonrpc": "2.0", "id": 1, "result": "name="}`},
+	} {
+		in := `{"jsonrpc": "2.0", "id": 1, "method": "options"` + c.Params + `}`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(in))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestRawParams(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethod("count", func(prefix string, dec *json.Decoder) (string, error) {
+		// Read the array one element at a time.
+		if _, err := dec.Token(); err != nil {
+			return "", err
+		}
+		var n int
+		for dec.More() {
+			var v struct{ ID int }
+			if err := dec.Decode(&v); err != nil {
+				return "", err
+			}
+			n++
+		}
+		return fmt.Sprintf("%s%d", prefix, n), nil
+	})
+	h.RegisterMethodNamed("size", func(r io.Reader) (int, error) {
+		b, err := ioutil.ReadAll(r)
+		return len(b), err
+	}, "data")
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "count",
+			"params": ["n=", [{"ID": 1}, {"ID": 2}, {"ID": 3}]]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": "n=3"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "size",
+			"params": {"data": "abc"}
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"result": 5
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"method": "size",
+			"params": [[1, 2]]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"result": 6
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+
+	if err := h.TryRegisterMethod("first", func(r io.Reader, s string) {}); err == nil {
+		t.Fatal("expected error registering an io.Reader before the last parameter")
+	}
+	if err := h.TryRegisterMethod("variadic", func(dec *json.Decoder, s ...string) {}); err == nil {
+		t.Fatal("expected error registering a *json.Decoder before a variadic parameter")
+	}
+}
+
+func TestRawMethod(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethod("quote", func(ctx context.Context, raw json.RawMessage) (json.RawMessage, error) {
+		// Return the params as a string, to show they were not modified.
+		if raw == nil {
+			return json.RawMessage(`"omitted"`), nil
+		}
+		if len(raw) > 20 {
+			return nil, &Error{Code: StatusInvalidParams, Message: "quote: params too long"}
+		}
+		return json.Marshal(string(raw))
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		Params string
+		Out    string
+	}
+	for i, c := range []compare{
+		{``, `{"jsonrpc": "2.0", "id": 1, "result": "omitted"}`},
+		{`, "params": null`, `{"jsonrpc": "2.0", "id": 1, "result": "omitted"}`},
+		{`, "params": [1,  2]`, `{"jsonrpc": "2.0", "id": 1, "result": "[1,  2]"}`},
+		{`, "params": {"a":1.50}`, `{"jsonrpc": "2.0", "id": 1, "result": "{\"a\":1.50}"}`},
+		{`, "params": "text"`, `{"jsonrpc": "2.0", "id": 1, "result": "\"text\""}`},
+		{`, "params": [1, 2, 3, 4, 5, 6, 7, 8]`, `{"jsonrpc": "2.0", "id": 1, "error": {"code": -32602, "message": "quote: params too long"}}`},
+	} {
+		in := `{"jsonrpc": "2.0", "id": 1, "method": "quote"` + c.Params + `}`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(in))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestZeroArgParams(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethod("ping", func() string {
+		return "pong"
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		Params string
+		Out    string
+	}
+	for i, c := range []compare{
+		{``, `{"jsonrpc": "2.0", "id": 1, "result": "pong"}`},
+		{`, "params": null`, `{"jsonrpc": "2.0", "id": 1, "result": "pong"}`},
+		{`, "params": []`, `{"jsonrpc": "2.0", "id": 1, "result": "pong"}`},
+		{`, "params": {}`, `{"jsonrpc": "2.0", "id": 1, "result": "pong"}`},
+		{`, "params": { }`, `{"jsonrpc": "2.0", "id": 1, "result": "pong"}`},
+		{`, "params": [1]`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"error": {"code": -32602, "message": "ping: require 0 params"}
+		}`},
+		{`, "params": {"a": 1}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"error": {"code": -32602, "message": "ping: require 0 params"}
+		}`},
+		{`, "params": "a"`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"error": {"code": -32602, "message": "ping: params must be array, object, or omitted"}
+		}`},
+	} {
+		in := `{"jsonrpc": "2.0", "id": 1, "method": "ping"` + c.Params + `}`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(in))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestStreamedParams(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethod("size", func(r io.Reader) (int, error) {
+		b, err := ioutil.ReadAll(r)
+		return len(b), err
+	})
+	h.RegisterMethod("peek", func(dec *json.Decoder) (string, error) {
+		// Read only the start of the param.
+		tok, err := dec.Token()
+		return fmt.Sprint(tok), err
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "size",
+			"params": ["a\"]b"],
+			"extra": {"params": [1]}
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"result": 7
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "size",
+			"params": [ 123 ]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"result": 3
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"method": "peek",
+			"params": [{"a": [1, 2, 3]}]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"result": "{"
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"method": "size",
+			"params": [[1, 2]],
+			"id": 4
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 4,
+			"result": 6
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 5,
+			"method": "peek",
+			"params": [[1, 2], 3]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 5,
+			"error": {
+				"code": -32602,
+				"message": "peek: require 1 params"
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 6,
+			"method": "peek",
+			"params": [[1, 2] x]
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 6,
+			"error": {
+				"code": -32700,
+				"message": "invalid character 'x' after array element"
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 7,
+			"method": "peek",
+			"params": [[1, 2]`, `{
+			"jsonrpc": "2.0",
+			"id": 7,
+			"error": {
+				"code": -32600,
+				"message": "unexpected EOF"
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestStreamedParamsArrive(t *testing.T) {
+	h := NewHandler()
+	first := make(chan int)
+	h.RegisterMethod("ingest", func(prefix string, dec *json.Decoder) (string, error) {
+		if _, err := dec.Token(); err != nil {
+			return "", err
+		}
+		var n int
+		for dec.More() {
+			var v struct{ ID int }
+			if err := dec.Decode(&v); err != nil {
+				return "", err
+			}
+			if n == 0 {
+				first <- v.ID
+			}
+			n++
+		}
+		return fmt.Sprintf("%s%d", prefix, n), nil
+	})
+
+	// The method must see the first record while the rest of the request
+	// has yet to be sent.
+	pr, pw := io.Pipe()
+	go func() {
+		io.WriteString(pw, `{"jsonrpc": "2.0", "id": 1, "method": "ingest", "params": ["n=", [{"ID": 1}, `)
+		select {
+		case <-first:
+		case <-time.After(time.Second):
+			t.Error("param was not streamed")
+		}
+		io.WriteString(pw, `{"ID": 2}, {"ID": 3}]]}`)
+		pw.Close()
+	}()
+	req := httptest.NewRequest("POST", "/", pr)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	expectJSON(t, w.Body, `{"jsonrpc": "2.0", "id": 1, "result": "n=3"}`)
+}
@@ -0,0 +1,94 @@
+package jsonrpc
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit limits how often the named method may be called. Calls that
+// exceed the limit are rejected with StatusRateLimited, and the error's Data
+// suggests how long the client should wait before retrying. Methods without a
+// limiter are unrestricted. A nil limiter removes the limit.
+//
+// A limiter applies across all transports and connections.
+func (h *Handler) RateLimit(name string, limiter *rate.Limiter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if limiter == nil {
+		delete(h.limits, name)
+		return
+	}
+	if h.limits == nil {
+		h.limits = make(map[string]*rate.Limiter)
+	}
+	h.limits[name] = limiter
+}
+
+// RetryData is the Data of errors that ask the client to retry later, such as
+// those with StatusRateLimited. Over HTTP, the same delay is also sent in the
+// Retry-After header.
+type RetryData struct {
+	RetryAfterMs int64 `json:"retryAfterMs"`
+}
+
+// setRetryAfter sets the Retry-After header of an HTTP response, if any of the
+// requests failed with RetryData. The longest delay is used, rounded up to
+// whole seconds.
+func setRetryAfter(w http.ResponseWriter, reqs ...*request) {
+	ms := int64(-1)
+	for _, req := range reqs {
+		if req.res.Error == nil {
+			continue
+		}
+		var d RetryData
+		switch data := req.res.Error.Data.(type) {
+		case RetryData:
+			d = data
+		case *RetryData:
+			if data == nil {
+				continue
+			}
+			d = *data
+		default:
+			continue
+		}
+		if d.RetryAfterMs > ms {
+			ms = d.RetryAfterMs
+		}
+	}
+	if ms >= 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt((ms+999)/1000, 10))
+	}
+}
+
+// checkRateLimit returns an error if the named method has exceeded its rate
+// limit.
+func (h *Handler) checkRateLimit(name string) *Error {
+	h.mu.RLock()
+	limiter, ok := h.limits[name]
+	if !ok {
+		limiter = h.limits[h.resolve(name)]
+	}
+	h.mu.RUnlock()
+	if limiter == nil {
+		return nil
+	}
+
+	r := limiter.Reserve()
+	if r.OK() && r.Delay() == 0 {
+		return nil
+	}
+	e := &Error{
+		Code:    StatusRateLimited,
+		Message: fmt.Sprintf("Rate limit exceeded: %s", name),
+	}
+	if r.OK() {
+		e.Data = RetryData{RetryAfterMs: r.Delay().Milliseconds()}
+		// The call is rejected, so it should not count against the limit.
+		r.Cancel()
+	}
+	return e
+}
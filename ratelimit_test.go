@@ -0,0 +1,85 @@
+package jsonrpc
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimit(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.RateLimit("Echoer.DelayEcho", rate.NewLimiter(rate.Every(time.Hour), 2))
+
+	var retryAfter string
+	call := func(method string) string {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "`+method+`",
+			"params": ["Hello world!", 0]
+		}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		retryAfter = w.Header().Get("Retry-After")
+		return w.Body.String()
+	}
+
+	// The burst is allowed, and then calls are rejected.
+	for i := 0; i < 2; i++ {
+		if got := call("Echoer.DelayEcho"); !strings.Contains(got, `"result"`) {
+			t.Fatalf("call %d: expected a result, got: %s", i, got)
+		}
+	}
+	got := call("Echoer.DelayEcho")
+	if !strings.Contains(got, `"code":-32000`) || !strings.Contains(got, `"retryAfterMs":`) {
+		t.Fatalf("expected a rate limit error, got: %s", got)
+	}
+	if retryAfter != "3600" {
+		t.Fatalf("expected Retry-After of 3600 seconds, got %q", retryAfter)
+	}
+
+	// An alias shares the limit of its target.
+	if err := h.Alias("delay", "Echoer.DelayEcho"); err != nil {
+		t.Fatal(err)
+	}
+	if got := call("delay"); !strings.Contains(got, `"code":-32000`) {
+		t.Fatalf("expected a rate limit error through the alias, got: %s", got)
+	}
+
+	// Within a batch, the Retry-After header is set if any call is limited.
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`[
+		{"jsonrpc": "2.0", "id": 1, "method": "Echoer.Echo", "params": ["Hello world!"]},
+		{"jsonrpc": "2.0", "id": 2, "method": "Echoer.DelayEcho", "params": ["Hello world!", 0]}
+	]`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get("Retry-After"); got != "3600" {
+		t.Fatalf("expected Retry-After of 3600 seconds for a batch, got %q", got)
+	}
+
+	// Other methods are unrestricted.
+	req = httptest.NewRequest("POST", "/", strings.NewReader(`{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"method": "Echoer.Echo",
+		"params": ["Hello world!"]
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	expectJSON(t, w.Body, `{"jsonrpc": "2.0", "id": 1, "result": "Hello world!"}`)
+	if got := w.Header().Get("Retry-After"); got != "" {
+		t.Fatalf("unexpected Retry-After %q", got)
+	}
+
+	// Removing the limiter lifts the restriction.
+	h.RateLimit("Echoer.DelayEcho", nil)
+	if got := call("Echoer.DelayEcho"); !strings.Contains(got, `"result"`) {
+		t.Fatalf("expected a result, got: %s", got)
+	}
+}
@@ -0,0 +1,46 @@
+package jsonrpc
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Raw is a result that writes the HTTP response itself instead of being sent
+// as a JSON-RPC response. It is an escape hatch for methods that stream bytes,
+// such as a file download, from an otherwise JSON-RPC server. For example:
+//
+//	h.RegisterMethod("download", func(name string) (jsonrpc.Raw, error) {
+//		f, err := os.Open(name)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return func(w http.ResponseWriter) error {
+//			defer f.Close()
+//			w.Header().Set("Content-Type", "application/octet-stream")
+//			_, err := io.Copy(w, f)
+//			return err
+//		}, nil
+//	})
+//
+// A Raw result is only supported for a single request under ServeHTTP, where
+// it is called once the method returns. Headers set by SetResponseHeader have
+// already been added, but the response is not compressed. The error it
+// returns is reported to the Logger as the error sending the response. A
+// notification's Raw result is not called, and a nil Raw sends an empty
+// response.
+//
+// In a batch, under ServeConn, or in any other case where there is no HTTP
+// response of its own, returning a Raw result sends an internal error instead.
+type Raw func(w http.ResponseWriter) error
+
+// checkRaw replaces a Raw result with an error unless the request is allowed
+// to write its own response.
+func checkRaw(req *request, result interface{}) (interface{}, error) {
+	if _, ok := result.(Raw); ok && !req.raw {
+		return nil, &Error{
+			Code:    StatusInternalError,
+			Message: fmt.Sprintf("%s: raw result requires a single HTTP request", req.Method),
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,62 @@
+package jsonrpc
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRaw(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethod("download", func(name string) (Raw, error) {
+		if name == "" {
+			return nil, &Error{Code: 404, Message: "not found"}
+		}
+		return func(w http.ResponseWriter) error {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, err := io.WriteString(w, "contents of "+name)
+			return err
+		}, nil
+	})
+
+	// Prepare test cases.
+	type compare struct {
+		In          string
+		Out         string
+		ContentType string
+	}
+	for i, c := range []compare{
+		{`{"jsonrpc": "2.0", "id": 1, "method": "download", "params": ["a.txt"]}`,
+			`contents of a.txt`, "application/octet-stream"},
+		{`{"jsonrpc": "2.0", "id": 1, "method": "download", "params": [""]}`,
+			`{"jsonrpc":"2.0","id":1,"error":{"code":404,"message":"not found"}}` + "\n", "application/json"},
+		{`{"jsonrpc": "2.0", "method": "download", "params": ["a.txt"]}`,
+			``, ""},
+		{`[{"jsonrpc": "2.0", "id": 1, "method": "download", "params": ["a.txt"]}]`,
+			`[{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"download: raw result requires a single HTTP request"}}]` + "\n", "application/json"},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		if got := w.Body.String(); got != c.Out {
+			t.Fatalf("expected: %s\ngot: %s", c.Out, got)
+		}
+		if got := w.Header().Get("Content-Type"); got != c.ContentType {
+			t.Fatalf("expected Content-Type %q, got %q", c.ContentType, got)
+		}
+	}
+
+	t.Log("Running bidirectional test: raw result")
+	testBidirectionalHandler(t, h,
+		func(pw *io.PipeWriter) {
+			pw.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "download", "params": ["a.txt"]}`))
+			pw.Close()
+		},
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"download: raw result requires a single HTTP request"}}
+`,
+	)
+}
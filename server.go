@@ -0,0 +1,131 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// ErrServerClosed is returned by Server.ServeConn once the Server has been
+// shut down.
+var ErrServerClosed = errors.New("jsonrpc: server closed")
+
+// Server serves a Handler while keeping track of what it is serving, so that
+// it can be shut down gracefully. The Handler holds the registry and the
+// configuration, and the Server controls its lifecycle.
+//
+// A Server is safe for concurrent use.
+type Server struct {
+	// Handler is the Handler being served. It must not be changed once the
+	// Server is in use.
+	Handler *Handler
+
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	conns    map[*Conn]struct{}
+	requests int
+	shutdown bool
+}
+
+// NewServer returns a Server that serves h.
+func NewServer(h *Handler) *Server {
+	return &Server{Handler: h}
+}
+
+// ServeHTTP serves an HTTP request using the Handler. Once the Server is
+// shutting down, requests are rejected with 503 Service Unavailable.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	s.requests++
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.requests--
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+	s.Handler.ServeHTTP(w, r)
+}
+
+// ServeConn serves a bi-directional stream using the Handler, the same way as
+// Handler.ServeConn does. It returns ErrServerClosed without reading from rw
+// if the Server is shutting down, and otherwise nil once the connection ends.
+func (s *Server) ServeConn(ctx context.Context, rw io.ReadWriter) error {
+	c := s.Handler.NewConn(rw)
+
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	if s.conns == nil {
+		s.conns = make(map[*Conn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+	c.Serve(ctx)
+	return nil
+}
+
+// InFlight returns the number of HTTP requests currently being served.
+func (s *Server) InFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Conns returns the number of connections currently being served.
+func (s *Server) Conns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Shutdown gracefully shuts down the Server. New HTTP requests and
+// connections are rejected, every active connection is shut down as by
+// Conn.Shutdown, and Shutdown waits for HTTP requests and connections in
+// progress to finish. If ctx expires first, Shutdown returns ctx.Err()
+// without waiting further.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	conns := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		go c.Shutdown(ctx)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
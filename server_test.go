@@ -0,0 +1,86 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerShutdown(t *testing.T) {
+	started := make(chan struct{}, 2)
+	h := NewHandler()
+	h.RegisterMethod("slow", func(ms int) string {
+		started <- struct{}{}
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return "done"
+	})
+	s := NewServer(h)
+
+	// Start a call over a connection and another over HTTP.
+	var buf bytes.Buffer
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ServeConn(context.Background(), struct {
+			io.Reader
+			io.Writer
+		}{pr, &buf})
+	}()
+	pw.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "slow", "params": [50]}`))
+	<-started
+
+	w := httptest.NewRecorder()
+	responded := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc": "2.0", "id": 2, "method": "slow", "params": [50]}`))
+		req.Header.Set("Content-Type", "application/json")
+		s.ServeHTTP(w, req)
+		close(responded)
+	}()
+	<-started
+
+	if n := s.Conns(); n != 1 {
+		t.Fatalf("expected 1 connection, got %d", n)
+	}
+	if n := s.InFlight(); n != 1 {
+		t.Fatalf("expected 1 request in flight, got %d", n)
+	}
+
+	t.Log("Running shutdown test: drain calls in progress")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+	<-responded
+	expected := `{"jsonrpc":"2.0","id":1,"result":"done"}
+`
+	if got := buf.String(); got != expected {
+		t.Fatalf("expected: %s\ngot: %s", expected, got)
+	}
+	expectJSON(t, w.Body, `{"jsonrpc": "2.0", "id": 2, "result": "done"}`)
+	if s.Conns() != 0 || s.InFlight() != 0 {
+		t.Fatalf("expected nothing in flight, got %d connections and %d requests", s.Conns(), s.InFlight())
+	}
+
+	t.Log("Running shutdown test: reject new calls")
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc": "2.0", "id": 3, "method": "slow", "params": [0]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if err := s.ServeConn(context.Background(), &bytes.Buffer{}); err != ErrServerClosed {
+		t.Fatalf("expected ErrServerClosed, got %v", err)
+	}
+}
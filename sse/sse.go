@@ -0,0 +1,125 @@
+/*
+Package sse pushes JSON-RPC 2.0 notifications to clients using Server-Sent
+Events, which browsers consume with EventSource.
+
+Each notification is sent as one event, whose data is the JSON-RPC message.
+For example:
+
+	h := sse.NewHandler(rpc, func(ctx context.Context, c *jsonrpc.Conn) {
+		go func() {
+			for ev := range events(ctx) {
+				c.Notify("event", ev)
+			}
+		}()
+	})
+	http.Handle("/events", h)
+*/
+package sse
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/chowey/jsonrpc"
+)
+
+// Handler is an http.Handler that holds GET requests open as event streams.
+type Handler struct {
+	// KeepAlive, if positive, is how often a comment is sent to keep the
+	// stream open while no notifications are sent.
+	KeepAlive time.Duration
+
+	rpc       *jsonrpc.Handler
+	subscribe func(ctx context.Context, c *jsonrpc.Conn)
+}
+
+// NewHandler initializes a new Handler. For every new stream, subscribe is
+// called with a Conn whose notifications are sent to the client. The Conn
+// may be kept until ctx is done, which happens once the client disconnects.
+// Notifications sent afterwards return jsonrpc.ErrConnClosed.
+func NewHandler(h *jsonrpc.Handler, subscribe func(ctx context.Context, c *jsonrpc.Conn)) *Handler {
+	return &Handler{rpc: h, subscribe: subscribe}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Unsupported method: must be GET", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := r.Context()
+	s := &stream{w: w, flusher: flusher}
+	c := h.rpc.NewConn(s)
+	h.subscribe(ctx, c)
+
+	var tick <-chan time.Time
+	if h.KeepAlive > 0 {
+		t := time.NewTicker(h.KeepAlive)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			// No more writes may be made once the handler returns.
+			c.Shutdown(context.Background())
+			return
+		case <-tick:
+			s.comment("keep-alive")
+		}
+	}
+}
+
+// stream adapts an HTTP response to an io.ReadWriter. Every write is sent as
+// a single event, and there is nothing to read.
+type stream struct {
+	mu      sync.Mutex
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	if err := s.send(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *stream) comment(text string) error {
+	return s.send([]byte(": " + text + "\n\n"))
+}
+
+func (s *stream) send(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(p); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
@@ -0,0 +1,77 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chowey/jsonrpc"
+)
+
+func TestHandler(t *testing.T) {
+	conns := make(chan *jsonrpc.Conn, 1)
+	h := NewHandler(jsonrpc.NewHandler(), func(ctx context.Context, c *jsonrpc.Conn) {
+		conns <- c
+	})
+	h.KeepAlive = 20 * time.Millisecond
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected Content-Type text/event-stream, got %q", ct)
+	}
+
+	c := <-conns
+	if err := c.Notify("event", map[string]string{"topic": "news"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the notification and a keep-alive.
+	var lines []string
+	sc := bufio.NewScanner(res.Body)
+	for sc.Scan() {
+		if line := sc.Text(); line != "" {
+			lines = append(lines, line)
+		}
+		if len(lines) == 2 {
+			break
+		}
+	}
+	expected := []string{
+		`data: {"jsonrpc":"2.0","method":"event","params":{"topic":"news"}}`,
+		`: keep-alive`,
+	}
+	for i := range expected {
+		if i >= len(lines) || lines[i] != expected[i] {
+			t.Fatalf("expected: %q\ngot: %q", expected, lines)
+		}
+	}
+
+	// Once the client disconnects, the Conn is closed.
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for c.Notify("event", nil) != jsonrpc.ErrConnClosed {
+		if time.Now().After(deadline) {
+			t.Fatal("Conn was not closed after the client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Only GET is allowed.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("")))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
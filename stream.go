@@ -0,0 +1,348 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// errStreamClosed is the error reading a streamed param after its request has
+// been answered.
+var errStreamClosed = errors.New("jsonrpc: read from streamed param after request finished")
+
+// errConcurrentRead is the error reading a streamed param while another read
+// is in progress.
+var errConcurrentRead = errors.New("jsonrpc: concurrent read from streamed param")
+
+// paramStream is the last param of a request, which the method reads from the
+// request body while it runs. It reads exactly one JSON value, and leaves the
+// rest of the body to finish.
+//
+// No lock is held while reading, so that finish never waits on the body for a
+// method that was abandoned, such as by the MethodTimeout, while reading.
+type paramStream struct {
+	m     *method
+	comma bool // Whether the param follows other params.
+	r     *bufio.Reader
+	err   error
+
+	taken   atomic.Bool
+	closed  atomic.Bool
+	reading atomic.Bool
+
+	// abandoned is set by finish if a read was still in progress, so that
+	// the body must not be reused.
+	abandoned bool
+
+	// The state of the scan for the end of the value.
+	started  bool
+	done     bool
+	scalar   bool
+	depth    int
+	inString bool
+	escape   bool
+}
+
+// streams reports whether a request may have its last param streamed to the
+// method, which requires the default Decoder and the request to be decoded
+// before any hook can change it.
+func (h *Handler) streams() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.streaming && h.Decoder == nil && h.Envelope == nil && h.RequestInterceptor == nil
+}
+
+// decodeStream begins decoding a single request from the body, so that its
+// last param can be streamed to the method. If it can be, then the request is
+// decoded and prepared with the params before it, and the stream is returned.
+// Otherwise it returns a reader that replays the body from the start, so the
+// request can be decoded as usual.
+func (h *Handler) decodeStream(ctx context.Context, body io.Reader, req *request) (*paramStream, io.Reader) {
+	var head bytes.Buffer
+	dec := json.NewDecoder(io.TeeReader(body, &head))
+	m, ok := h.decodeHead(dec, req)
+	if !ok {
+		*req = request{}
+		return nil, io.MultiReader(&head, body)
+	}
+	h.prepareRequest(ctx, req)
+	return &paramStream{
+		m:     m,
+		comma: m.nargs > 1,
+		r:     bufio.NewReader(io.MultiReader(dec.Buffered(), body)),
+	}, nil
+}
+
+// decodeHead decodes the members of a request up to the first element of its
+// params that is to be streamed. Keys match as they would when the request is
+// decoded whole. It reports false if the request cannot be streamed: it must
+// have its "id" and "method" before its "params", which must be an array that
+// includes the streamed param, and the method must take one.
+func (h *Handler) decodeHead(dec *json.Decoder, req *request) (*method, bool) {
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, false
+	}
+	var hasID bool
+	for dec.More() {
+		t, err := dec.Token()
+		key, ok := t.(string)
+		if err != nil || !ok {
+			return nil, false
+		}
+		switch {
+		case strings.EqualFold(key, "jsonrpc"):
+			err = dec.Decode(&req.Protocol)
+		case strings.EqualFold(key, "id"):
+			err = dec.Decode(&req.ID)
+			hasID = true
+		case strings.EqualFold(key, "method"):
+			err = dec.Decode(&req.Method)
+		case strings.EqualFold(key, "idempotencyKey"):
+			err = dec.Decode(&req.IdempotencyKey)
+		case strings.EqualFold(key, "params"):
+			m := h.lookup(req.Method)
+			if !hasID || m == nil || !m.stream {
+				return nil, false
+			}
+			req.Params, ok = decodeLeadingParams(dec, m.nargs-1)
+			return m, ok
+		default:
+			err = dec.Decode(new(json.RawMessage))
+		}
+		if err != nil {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// decodeLeadingParams decodes the first n elements of an array of params. It
+// reports false unless another element follows them.
+func decodeLeadingParams(dec *json.Decoder, n int) (json.RawMessage, bool) {
+	if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+		return nil, false
+	}
+	params := []byte{'['}
+	for i := 0; i < n; i++ {
+		var arg json.RawMessage
+		if !dec.More() || dec.Decode(&arg) != nil {
+			return nil, false
+		}
+		if i > 0 {
+			params = append(params, ',')
+		}
+		params = append(params, arg...)
+	}
+	if !dec.More() {
+		return nil, false
+	}
+	return append(params, ']'), true
+}
+
+// take reports whether m may read the stream. It may only be read once, by the
+// method it was decoded for.
+func (s *paramStream) take(m *method) bool {
+	return s.m == m && !s.closed.Load() && s.taken.CompareAndSwap(false, true)
+}
+
+func (s *paramStream) Read(p []byte) (int, error) {
+	if !s.reading.CompareAndSwap(false, true) {
+		return 0, errConcurrentRead
+	}
+	defer s.reading.Store(false)
+	// Once closed is set, finish may be using the reader.
+	if s.closed.Load() {
+		return 0, errStreamClosed
+	}
+	return s.read(p)
+}
+
+// read copies the JSON text of the param into p, stopping at the end of its
+// value.
+func (s *paramStream) read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if !s.started {
+		if s.comma {
+			if s.err = s.expect(','); s.err != nil {
+				return 0, s.err
+			}
+		}
+		s.started = true
+	}
+	n := 0
+	for n < len(p) && !s.done {
+		if n > 0 && s.r.Buffered() == 0 {
+			// Return what has arrived rather than wait for more.
+			break
+		}
+		c, err := s.r.ReadByte()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			s.err = err
+			return n, err
+		}
+		if n == 0 && s.depth == 0 && !s.scalar && !s.inString {
+			// This is the first byte of the value.
+			if isSpace(c) {
+				continue
+			}
+			switch c {
+			case '{', '[', '"':
+			case ',', ']', '}', ':':
+				s.err = &Error{
+					Code:    StatusParseError,
+					Message: fmt.Sprintf("invalid character %q looking for beginning of value", c),
+				}
+				return 0, s.err
+			default:
+				s.scalar = true
+			}
+		}
+		switch {
+		case s.inString:
+			switch {
+			case s.escape:
+				s.escape = false
+			case c == '\\':
+				s.escape = true
+			case c == '"':
+				s.inString = false
+				s.done = s.depth == 0
+			}
+		case s.scalar:
+			if isSpace(c) || c == ',' || c == ']' || c == '}' {
+				s.r.UnreadByte()
+				s.done = true
+				continue
+			}
+		case c == '"':
+			s.inString = true
+		case c == '{' || c == '[':
+			s.depth++
+		case c == '}' || c == ']':
+			s.depth--
+			s.done = s.depth == 0
+		}
+		p[n] = c
+		n++
+	}
+	if n == 0 && s.done {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// expect skips whitespace and then reads c.
+func (s *paramStream) expect(c byte) error {
+	b, err := s.next()
+	if err != nil {
+		return err
+	}
+	if b != c {
+		return &Error{
+			Code:    StatusParseError,
+			Message: fmt.Sprintf("invalid character %q after array element", b),
+		}
+	}
+	return nil
+}
+
+// next returns the next byte that is not whitespace.
+func (s *paramStream) next() (byte, error) {
+	for {
+		c, err := s.r.ReadByte()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil || !isSpace(c) {
+			return c, err
+		}
+	}
+}
+
+// finish stops the method from reading the stream. If drain is set, then the
+// rest of the request is read, and an error is reported if it is malformed or
+// has more params than the method takes. Members of the request after its
+// params are ignored. Otherwise only an error the method ran into reading the
+// stream is reported.
+//
+// If the method is still reading, then the stream is abandoned instead: it is
+// left to that read, and nothing is reported.
+func (s *paramStream) finish(drain bool) error {
+	s.closed.Store(true)
+	if s.reading.Load() {
+		s.abandoned = true
+		return nil
+	}
+	if !drain {
+		return s.err
+	}
+
+	// Read whatever of the param the method did not.
+	var buf [512]byte
+	for {
+		_, err := s.read(buf[:])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// The param must be the last one.
+	c, err := s.next()
+	if err != nil {
+		return err
+	}
+	switch c {
+	case ']':
+	case ',':
+		msg := fmt.Sprintf("%s: require %d params", s.m.name, s.m.nargs)
+		if s.m.optional > 0 {
+			msg = fmt.Sprintf("%s: require %d to %d params", s.m.name, s.m.nargs-s.m.optional, s.m.nargs)
+		}
+		return &Error{Code: StatusInvalidParams, Message: msg}
+	default:
+		return &Error{
+			Code:    StatusParseError,
+			Message: fmt.Sprintf("invalid character %q after array element", c),
+		}
+	}
+
+	// Skip the members that follow.
+	if c, err = s.next(); err != nil {
+		return err
+	}
+	switch c {
+	case '}':
+		return nil
+	case ',':
+		rest := io.MultiReader(strings.NewReader("{"), s.r)
+		if err := json.NewDecoder(rest).Decode(new(struct{})); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				return &Error{Code: StatusParseError, Message: err.Error()}
+			}
+			return err
+		}
+		return nil
+	}
+	return &Error{
+		Code:    StatusParseError,
+		Message: fmt.Sprintf("invalid character %q after object key:value pair", c),
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
@@ -0,0 +1,115 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestParamStreamScan(t *testing.T) {
+	// Prepare test cases. Each input follows the leading params, so the
+	// streamed value must be read and the rest left unread.
+	type compare struct {
+		In    string
+		Comma bool
+		Value string
+		Rest  string
+		Err   string
+	}
+	for i, c := range []compare{
+		{In: `"\\\""]`, Value: `"\\\""`, Rest: `]`},
+		{In: `""]`, Value: `""`, Rest: `]`},
+		{In: `"]"]`, Value: `"]"`, Rest: `]`},
+		{In: `"a]b[\"}" ]`, Value: `"a]b[\"}"`, Rest: ` ]`},
+		{In: `["]", ["[", "\"]"], {"}": "{"}]]`, Value: `["]", ["[", "\"]"], {"}": "{"}]`, Rest: `]`},
+		{In: " \n\t{\"a\": [1, {\"b\": \"c\"}]} , 2]", Value: `{"a": [1, {"b": "c"}]}`, Rest: ` , 2]`},
+		{In: `123]`, Value: `123`, Rest: `]`},
+		{In: "-1.5e+3\n]", Value: `-1.5e+3`, Rest: "\n]"},
+		{In: `true,1]`, Value: `true`, Rest: `,1]`},
+		{In: `null}`, Value: `null`, Rest: `}`},
+		{In: ` , "x"]`, Comma: true, Value: `"x"`, Rest: `]`},
+		{In: `123`, Value: `123`, Err: "unexpected EOF"},
+		{In: `"abc`, Value: `"abc`, Err: "unexpected EOF"},
+		{In: `[1, [2]`, Value: `[1, [2]`, Err: "unexpected EOF"},
+		{In: ``, Err: "unexpected EOF"},
+		{In: ` ]`, Err: "invalid character ']' looking for beginning of value"},
+		{In: `"x"]`, Comma: true, Err: "invalid character '\"' after array element"},
+	} {
+		t.Logf("Running test %d", i)
+		for _, oneByte := range []bool{false, true} {
+			s := &paramStream{r: bufio.NewReader(strings.NewReader(c.In)), comma: c.Comma}
+			var r io.Reader = s
+			if oneByte {
+				r = iotest.OneByteReader(s)
+			}
+			value, err := ioutil.ReadAll(r)
+			if c.Err != "" {
+				if err == nil || err.Error() != c.Err {
+					t.Fatalf("expected error %q, got %v", c.Err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if string(value) != c.Value {
+				t.Fatalf("expected value %q, got %q", c.Value, value)
+			}
+			if c.Err != "" {
+				continue
+			}
+			rest, _ := ioutil.ReadAll(s.r)
+			if string(rest) != c.Rest {
+				t.Fatalf("expected rest %q, got %q", c.Rest, rest)
+			}
+		}
+	}
+}
+
+func TestStreamedParamsTimeout(t *testing.T) {
+	h := NewHandler()
+	h.MethodTimeout = 50 * time.Millisecond
+	h.RegisterMethod("size", func(r io.Reader) (int, error) {
+		b, err := ioutil.ReadAll(r)
+		return len(b), err
+	})
+
+	// The method blocks reading a param that the client never finishes, but
+	// the response must not wait for it.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go io.WriteString(pw, `{"jsonrpc": "2.0", "id": 1, "method": "size", "params": ["abc`)
+	req := httptest.NewRequest("POST", "/", pr)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	h.ServeHTTP(w, req)
+	if d := time.Since(start); d > 250*time.Millisecond {
+		t.Fatalf("response waited on the abandoned method: took %v", d)
+	}
+	expectJSON(t, w.Body, `{
+		"jsonrpc": "2.0",
+		"id": 1,
+		"error": {
+			"code": -32004,
+			"message": "Deadline exceeded"
+		}
+	}`)
+}
+
+func TestStreamedParamsUnregister(t *testing.T) {
+	h := NewHandler()
+	h.RegisterMethod("size", func(ctx context.Context, dec *json.Decoder) {})
+	if !h.streams() {
+		t.Fatal("expected params to be streamed")
+	}
+	h.Unregister("size")
+	if h.streams() {
+		t.Fatal("expected params not to be streamed once no method takes them")
+	}
+}
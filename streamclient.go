@@ -0,0 +1,290 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDisconnected is returned for calls in progress on a StreamClient when its
+// connection is lost. The call may or may not have been made.
+var ErrDisconnected = errors.New("jsonrpc: disconnected")
+
+// StreamClient makes JSON-RPC 2.0 calls over a bi-directional stream, such as
+// one served by Handler.ServeConn. It receives the server's notifications, and
+// redials whenever the connection is lost. It is safe for concurrent use.
+type StreamClient struct {
+	// OnNotification, if specified, is called with every notification sent
+	// by the server, one at a time and in order. Responses are not read while
+	// it runs, so it must not wait for calls on the same StreamClient.
+	OnNotification func(method string, params json.RawMessage)
+
+	// OnConnect, if specified, is called in its own goroutine every time a
+	// connection is established, including the first. Calls may be made from
+	// it, for example to subscribe again after reconnecting.
+	OnConnect func(ctx context.Context)
+
+	// MinBackoff and MaxBackoff bound how long to wait before redialing. The
+	// wait starts at MinBackoff and doubles after every failed dial, up to
+	// MaxBackoff. By default they are 100ms and 10s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	dial func(ctx context.Context) (io.ReadWriteCloser, error)
+	id   uint64
+	done chan struct{}
+	wmu  sync.Mutex // Serializes writes to the connection.
+
+	mu      sync.Mutex
+	conn    io.ReadWriteCloser
+	enc     *json.Encoder
+	ready   chan struct{} // Closed once connected, or once closed.
+	pending map[string]chan streamResult
+	cancel  context.CancelFunc
+	closed  bool
+}
+
+type streamResult struct {
+	res *clientResponse
+	err error
+}
+
+// streamMessage is a response or notification sent by the server.
+type streamMessage struct {
+	clientResponse
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// NewStreamClient returns a StreamClient that connects using dial once Start
+// is called.
+func NewStreamClient(dial func(ctx context.Context) (io.ReadWriteCloser, error)) *StreamClient {
+	return &StreamClient{
+		dial:  dial,
+		done:  make(chan struct{}),
+		ready: make(chan struct{}),
+	}
+}
+
+// Start dials the first connection, and returns an error if that fails.
+// Otherwise the StreamClient stays connected, redialing as needed, until Close
+// is called or ctx is done.
+func (c *StreamClient) Start(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c.mu.Lock()
+	c.cancel = cancel
+	c.mu.Unlock()
+	go c.run(ctx, conn)
+	return nil
+}
+
+// Close closes the connection and stops redialing. Calls in progress, and any
+// made later, return ErrConnClosed.
+func (c *StreamClient) Close() error {
+	c.mu.Lock()
+	cancel := c.cancel
+	c.mu.Unlock()
+	if cancel == nil {
+		// Never started.
+		return nil
+	}
+	cancel()
+	<-c.done
+	return nil
+}
+
+func (c *StreamClient) run(ctx context.Context, conn io.ReadWriteCloser) {
+	defer close(c.done)
+	defer func() {
+		c.mu.Lock()
+		c.closed = true
+		if c.enc == nil {
+			close(c.ready)
+		}
+		c.mu.Unlock()
+	}()
+
+	min, max := c.MinBackoff, c.MaxBackoff
+	if min <= 0 {
+		min = 100 * time.Millisecond
+	}
+	if max < min {
+		max = 10 * time.Second
+		if max < min {
+			max = min
+		}
+	}
+
+	for {
+		c.serve(ctx, conn)
+
+		// Redial until connected.
+		backoff := min
+		for {
+			t := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+			var err error
+			if conn, err = c.dial(ctx); err == nil {
+				break
+			}
+			if backoff *= 2; backoff > max {
+				backoff = max
+			}
+		}
+	}
+}
+
+// serve reads messages from the connection until it fails or ctx is done.
+func (c *StreamClient) serve(ctx context.Context, conn io.ReadWriteCloser) {
+	c.mu.Lock()
+	c.conn = conn
+	c.enc = json.NewEncoder(conn)
+	close(c.ready)
+	c.mu.Unlock()
+
+	// Unblock reading once ctx is done.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	if c.OnConnect != nil {
+		go c.OnConnect(ctx)
+	}
+
+	dec := json.NewDecoder(conn)
+	for {
+		var msg streamMessage
+		if err := dec.Decode(&msg); err != nil {
+			break
+		}
+		if msg.Method != "" {
+			if msg.ID == nil && c.OnNotification != nil {
+				c.OnNotification(msg.Method, msg.Params)
+			}
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[string(msg.ID)]
+		delete(c.pending, string(msg.ID))
+		c.mu.Unlock()
+		if ok {
+			ch <- streamResult{res: &msg.clientResponse}
+		}
+	}
+	conn.Close()
+
+	// Fail the calls in progress.
+	err := ErrDisconnected
+	if ctx.Err() != nil {
+		err = ErrConnClosed
+	}
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.conn = nil
+	c.enc = nil
+	c.ready = make(chan struct{})
+	c.mu.Unlock()
+	for _, ch := range pending {
+		ch <- streamResult{err: err}
+	}
+}
+
+// Call calls the method with the given params and unmarshals the result into
+// result, as Client.Call does. While disconnected, Call waits for the
+// connection to be restored or for ctx to be done. If the connection is lost
+// before the response arrives, Call returns ErrDisconnected.
+func (c *StreamClient) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	id := json.RawMessage(strconv.AppendUint(nil, atomic.AddUint64(&c.id, 1), 10))
+	ch := make(chan streamResult, 1)
+	if err := c.send(ctx, method, id, params, ch); err != nil {
+		return err
+	}
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return r.err
+		}
+		return r.res.unmarshal(method, result)
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, string(id))
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Notify sends a notification, which is a call where the server does not
+// respond. While disconnected, Notify waits for the connection to be restored
+// or for ctx to be done.
+func (c *StreamClient) Notify(ctx context.Context, method string, params interface{}) error {
+	return c.send(ctx, method, nil, params, nil)
+}
+
+// send writes a request once connected. If ch is not nil, it receives the
+// response.
+func (c *StreamClient) send(ctx context.Context, method string, id json.RawMessage, params interface{}, ch chan streamResult) error {
+	req, err := newClientRequest(method, id, params)
+	if err != nil {
+		return err
+	}
+
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return ErrConnClosed
+		}
+		if c.enc != nil {
+			break
+		}
+		ready := c.ready
+		c.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	enc := c.enc
+	if ch != nil {
+		if c.pending == nil {
+			c.pending = make(map[string]chan streamResult)
+		}
+		c.pending[string(id)] = ch
+	}
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	err = enc.Encode(req)
+	c.wmu.Unlock()
+	if err != nil {
+		c.mu.Lock()
+		delete(c.pending, string(id))
+		c.mu.Unlock()
+		return ErrDisconnected
+	}
+	return nil
+}
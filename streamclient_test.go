@@ -0,0 +1,90 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStreamClient(t *testing.T) {
+	started := make(chan struct{}, 1)
+	h := NewHandler(&Echoer{})
+	h.RegisterMethod("subscribe", func(ctx context.Context, topic string) error {
+		c, _ := ConnFromContext(ctx)
+		return c.Notify("event", []string{topic})
+	})
+	h.RegisterMethod("block", func(ctx context.Context) {
+		started <- struct{}{}
+		<-ctx.Done()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Each dial serves a new connection, which the test can break.
+	servers := make(chan net.Conn, 2)
+	dial := func(ctx context.Context) (io.ReadWriteCloser, error) {
+		client, server := net.Pipe()
+		go h.ServeConnAndClose(ctx, server)
+		servers <- server
+		return client, nil
+	}
+
+	events := make(chan string, 2)
+	c := NewStreamClient(dial)
+	c.MinBackoff = time.Millisecond
+	c.OnNotification = func(method string, params json.RawMessage) {
+		events <- method + " " + string(params)
+	}
+	c.OnConnect = func(ctx context.Context) {
+		c.Call(ctx, "subscribe", "news", nil)
+	}
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	server := <-servers
+
+	if got := <-events; got != `event ["news"]` {
+		t.Fatalf("unexpected notification: %s", got)
+	}
+	var s string
+	if err := c.Call(ctx, "Echoer.Echo", "Hello world!", &s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "Hello world!" {
+		t.Fatalf("expected %q, got %q", "Hello world!", s)
+	}
+	if err := c.Call(ctx, "unknown", nil, nil); err == nil || err.(*Error).Code != StatusMethodNotFound {
+		t.Fatalf("expected method not found, got %v", err)
+	}
+
+	t.Log("Running reconnect test: fail calls in progress")
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.Call(ctx, "block", nil, nil)
+	}()
+	<-started
+	server.Close()
+	if err := <-errs; err != ErrDisconnected {
+		t.Fatalf("expected %v, got %v", ErrDisconnected, err)
+	}
+
+	// The client redials and subscribes again.
+	<-servers
+	if got := <-events; got != `event ["news"]` {
+		t.Fatalf("unexpected notification: %s", got)
+	}
+	if err := c.Call(ctx, "Echoer.Echo", "again", &s); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Running close test: reject calls")
+	c.Close()
+	if err := c.Call(ctx, "Echoer.Echo", "closed", &s); err != ErrConnClosed {
+		t.Fatalf("expected %v, got %v", ErrConnClosed, err)
+	}
+}
@@ -0,0 +1,94 @@
+package jsonrpc
+
+import "sort"
+
+// maxSuggestions is the most method names suggested when a method is not
+// found.
+const maxSuggestions = 3
+
+// SuggestionData is the Data of method-not-found errors when
+// MethodSuggestions is set.
+type SuggestionData struct {
+	Suggestions []string `json:"suggestions"`
+}
+
+// methodNotFound returns the error for calling an unknown method, suggesting
+// similarly named methods if MethodSuggestions is set.
+func (h *Handler) methodNotFound(name string) *Error {
+	e := methodNotFound(name)
+	if h.MethodSuggestions {
+		if suggestions := h.suggest(name); len(suggestions) > 0 {
+			e.Data = SuggestionData{Suggestions: suggestions}
+		}
+	}
+	return e
+}
+
+// suggest returns the registered names closest to name by edit distance,
+// closest first. Names too different from name are not suggested.
+func (h *Handler) suggest(name string) []string {
+	maxDistance := len(name) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	h.mu.RLock()
+	for s := range h.registry {
+		if d := levenshtein(name, s); d <= maxDistance {
+			candidates = append(candidates, candidate{s, d})
+		}
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	if len(candidates) > maxSuggestions {
+		candidates = candidates[:maxSuggestions]
+	}
+	suggestions := make([]string, len(candidates))
+	for i, c := range candidates {
+		suggestions[i] = c.name
+	}
+	return suggestions
+}
+
+// levenshtein returns the number of single-byte insertions, deletions and
+// substitutions needed to turn a into b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
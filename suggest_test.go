@@ -0,0 +1,83 @@
+package jsonrpc
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMethodSuggestions(t *testing.T) {
+	h := NewHandler(&Echoer{})
+	h.MethodSuggestions = true
+	h.RegisterMethod("echo", func(s string) string { return s })
+
+	// Prepare test cases.
+	type compare struct {
+		In  string
+		Out string
+	}
+	for i, c := range []compare{
+		{`{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"method": "Echoer.Ecoh"
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 1,
+			"error": {
+				"code": -32601,
+				"message": "No such method: Echoer.Ecoh",
+				"data": {"suggestions": ["Echoer.Echo"]}
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"method": "ech"
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 2,
+			"error": {
+				"code": -32601,
+				"message": "No such method: ech",
+				"data": {"suggestions": ["echo"]}
+			}
+		}`},
+		{`{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"method": "unknown"
+		}`, `{
+			"jsonrpc": "2.0",
+			"id": 3,
+			"error": {
+				"code": -32601,
+				"message": "No such method: unknown"
+			}
+		}`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(c.In))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		t.Logf("Running test %d", i)
+		expectJSON(t, w.Body, c.Out)
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	for _, c := range []struct {
+		A, B     string
+		Distance int
+	}{
+		{"", "", 0},
+		{"echo", "", 4},
+		{"echo", "echo", 0},
+		{"echo", "ecoh", 2},
+		{"kitten", "sitting", 3},
+	} {
+		if d := levenshtein(c.A, c.B); d != c.Distance {
+			t.Fatalf("levenshtein(%q, %q): expected %d, got %d", c.A, c.B, c.Distance, d)
+		}
+	}
+}
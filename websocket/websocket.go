@@ -0,0 +1,123 @@
+/*
+Package websocket serves JSON-RPC 2.0 over WebSocket connections.
+
+Each JSON-RPC message is sent as exactly one WebSocket text frame. For example:
+
+	h := jsonrpc.NewHandler(&Echo{})
+	http.ListenAndServe(":8080", websocket.NewHandler(h))
+*/
+package websocket
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/chowey/jsonrpc"
+	"github.com/gorilla/websocket"
+)
+
+// Handler is an http.Handler that upgrades requests to WebSocket connections
+// and serves JSON-RPC 2.0 over them using jsonrpc.Handler.ServeConn.
+type Handler struct {
+	// Upgrader configures how HTTP requests are upgraded to WebSocket
+	// connections. By default the Upgrader only accepts requests from the
+	// same origin.
+	Upgrader websocket.Upgrader
+
+	// PingInterval, if positive, is how often a ping is sent to the client.
+	// If the client does not respond with a pong within two intervals, then
+	// the connection is closed.
+	PingInterval time.Duration
+
+	rpc *jsonrpc.Handler
+}
+
+// NewHandler initializes a new Handler that serves the methods of h.
+func NewHandler(h *jsonrpc.Handler) *Handler {
+	return &Handler{rpc: h}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := h.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The Upgrader has already responded with an HTTP error.
+		return
+	}
+	defer ws.Close()
+
+	// Methods are cancelled once the socket closes.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	if h.PingInterval > 0 {
+		ws.SetReadDeadline(time.Now().Add(2 * h.PingInterval))
+		ws.SetPongHandler(func(string) error {
+			return ws.SetReadDeadline(time.Now().Add(2 * h.PingInterval))
+		})
+		go ping(ctx, ws, h.PingInterval)
+	}
+
+	h.rpc.ServeConn(ctx, &conn{ws: ws, cancel: cancel})
+
+	ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+}
+
+// ping sends pings at every interval until the context is done.
+func ping(ctx context.Context, ws *websocket.Conn, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// conn adapts a WebSocket connection to an io.ReadWriter. Reads span frame
+// boundaries, and every write is sent as a single text frame.
+type conn struct {
+	ws     *websocket.Conn
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	for {
+		if c.r == nil {
+			_, r, err := c.ws.NextReader()
+			if err != nil {
+				// The socket is closed or broken, so no more messages are
+				// available and nobody is left to receive a response.
+				c.cancel()
+				return 0, io.EOF
+			}
+			c.r = r
+		}
+		n, err := c.r.Read(p)
+		if err == io.EOF {
+			// Continue with the next frame.
+			c.r = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	if err := c.ws.WriteMessage(websocket.TextMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chowey/jsonrpc"
+	"github.com/gorilla/websocket"
+)
+
+type Echoer struct{}
+
+func (Echoer) Echo(s string) string {
+	return s
+}
+
+func (Echoer) DelayEcho(s string, ms int) string {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	return s
+}
+
+func TestHandler(t *testing.T) {
+	h := NewHandler(jsonrpc.NewHandler(Echoer{}))
+	h.PingInterval = 50 * time.Millisecond
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	for _, msg := range []string{
+		`{"jsonrpc": "2.0", "id": 1, "method": "Echoer.DelayEcho", "params": ["first", 200]}`,
+		`{"jsonrpc": "2.0", "method": "Echoer.Echo", "params": ["notification"]}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "Echoer.Echo", "params": ["second"]}`,
+	} {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each response arrives in its own frame, including while pings are sent
+	// in the background.
+	for _, expected := range []string{
+		`{"jsonrpc":"2.0","id":2,"result":"second"}` + "\n",
+		`{"jsonrpc":"2.0","id":1,"result":"first"}` + "\n",
+	} {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		typ, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != websocket.TextMessage {
+			t.Fatalf("expected a text frame, got type %d", typ)
+		}
+		if string(msg) != expected {
+			t.Fatalf("expected: %s\ngot: %s", expected, msg)
+		}
+	}
+
+	// Closing the socket ends the connection cleanly.
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := ws.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("expected a normal close, got: %v", err)
+	}
+}